@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// parse the space separated CIDRs of the trusted proxies, a single IP
+// address is trusted on its own
+func parseTrustedProxies(val string) ([]*net.IPNet, error) {
+	var proxies []*net.IPNet
+
+	for _, entry := range strings.Fields(val) {
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", entry)
+			}
+
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q", entry)
+		}
+
+		proxies = append(proxies, network)
+	}
+
+	return proxies, nil
+}
+
+func (app *application) trustedProxy(ip net.IP) bool {
+	for _, network := range app.config.proxies.trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// resolve the IP address of the client, the forwarding headers are only
+// honored when the direct peer is a trusted proxy, X-Forwarded-For is walked
+// from the right and the first address which isn't a trusted proxy is the client
+func (app *application) resolveClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	peer := net.ParseIP(host)
+	if peer == nil || !app.trustedProxy(peer) {
+		return host
+	}
+
+	// the proxies append to the header so only its right part can be trusted,
+	// the header might also be split into several lines
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+
+	client := peer
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+
+		ip := net.ParseIP(hop)
+		if ip == nil {
+			// whatever's left of a malformed address can't be trusted
+			break
+		}
+
+		client = ip
+
+		if !app.trustedProxy(ip) {
+			break
+		}
+	}
+
+	// a proxy which only sets X-Real-IP
+	if client.Equal(peer) {
+		if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+			client = ip
+		}
+	}
+
+	return client.String()
+}
+
+// resolve the client's IP address once and put it in the request context
+func (app *application) clientIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = app.contextSetClientIP(r, app.resolveClientIP(r))
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -5,6 +5,7 @@ import (
 	"net/http"
 
 	"api.cinevie.jpranata.tech/internal/data"
+	"api.cinevie.jpranata.tech/internal/jwt"
 )
 
 // custom contextKey type with the underlying type string
@@ -14,6 +15,20 @@ type contextKey string
 // it to the userContextKey
 const userContextKey = contextKey("user")
 
+// the permissions of the user are loaded once per request along with the user
+const permissionsContextKey = contextKey("permissions")
+
+// the session is only present when the request has been authenticated
+// with an authentication token rather than an API key
+const sessionContextKey = contextKey("session")
+
+// set when the request has been authenticated with a signed token
+// whose claims only carry a part of the user record
+const claimsContextKey = contextKey("claims")
+
+// the client's IP address resolved from the trusted proxies' headers
+const clientIPContextKey = contextKey("client_ip")
+
 // returns a new copy of the request with the provided User
 // struct added to the context
 func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
@@ -32,3 +47,72 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 
 	return user
 }
+
+// returns a new copy of the request with the user's permissions added to the context
+func (app *application) contextSetPermissions(r *http.Request, permissions data.Permissions) *http.Request {
+	ctx := context.WithValue(r.Context(), permissionsContextKey, permissions)
+
+	return r.WithContext(ctx)
+}
+
+// like contextGetUser the permissions must be present in the context
+// once the request has passed through the authenticate middleware
+func (app *application) contextGetPermissions(r *http.Request) data.Permissions {
+	permissions, ok := r.Context().Value(permissionsContextKey).(data.Permissions)
+	if !ok {
+		panic("missing permissions value in request context")
+	}
+
+	return permissions
+}
+
+func (app *application) contextSetSession(r *http.Request, session *data.Session) *http.Request {
+	ctx := context.WithValue(r.Context(), sessionContextKey, session)
+
+	return r.WithContext(ctx)
+}
+
+// unlike the user the session is optional so nil is returned when it's missing
+func (app *application) contextGetSession(r *http.Request) *data.Session {
+	session, ok := r.Context().Value(sessionContextKey).(*data.Session)
+	if !ok {
+		return nil
+	}
+
+	return session
+}
+
+func (app *application) contextSetClientIP(r *http.Request, ip string) *http.Request {
+	ctx := context.WithValue(r.Context(), clientIPContextKey, ip)
+
+	return r.WithContext(ctx)
+}
+
+// the IP address is resolved again when the request hasn't passed
+// through the clientIP middleware yet
+func (app *application) contextGetClientIP(r *http.Request) string {
+	ip, ok := r.Context().Value(clientIPContextKey).(string)
+	if !ok {
+		return app.resolveClientIP(r)
+	}
+
+	return ip
+}
+
+func (app *application) contextSetClaims(r *http.Request, claims *jwt.Claims) *http.Request {
+	ctx := context.WithValue(r.Context(), claimsContextKey, claims)
+
+	return r.WithContext(ctx)
+}
+
+// returns the complete record of the user in the context, which has to be
+// loaded from the database when it has been built from the claims of a signed token
+func (app *application) currentUser(r *http.Request) (*data.User, error) {
+	user := app.contextGetUser(r)
+
+	if _, ok := r.Context().Value(claimsContextKey).(*jwt.Claims); !ok {
+		return user, nil
+	}
+
+	return app.models.Users.Get(user.ID)
+}
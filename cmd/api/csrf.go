@@ -0,0 +1,106 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"api.cinevie.jpranata.tech/internal/jsonlog"
+)
+
+// the CSRF token of a session is the HMAC of its id, so it doesn't need to be
+// stored and stays the same while the session's tokens are being refreshed
+func (app *application) csrfToken(sessionID int64) string {
+	mac := hmac.New(sha256.New, app.csrfKey)
+	mac.Write([]byte("csrf:" + strconv.FormatInt(sessionID, 10)))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// check the CSRF token sent in the X-CSRF-Token header against the session
+func (app *application) validCSRFToken(r *http.Request, sessionID int64) bool {
+	token := r.Header.Get("X-CSRF-Token")
+	if token == "" {
+		return false
+	}
+
+	return hmac.Equal([]byte(token), []byte(app.csrfToken(sessionID)))
+}
+
+// check whether the request comes from the API itself or one of the trusted
+// origins, the Referer is used when the browser doesn't send the Origin and
+// requests without either are left to the CSRF token
+func (app *application) trustedRequestOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+
+	if origin == "" {
+		referer := r.Header.Get("Referer")
+		if referer == "" {
+			return true
+		}
+
+		u, err := url.Parse(referer)
+		if err != nil || u.Host == "" {
+			return false
+		}
+
+		origin = u.Scheme + "://" + u.Host
+	}
+
+	u, err := url.Parse(origin)
+	if err == nil && u.Host == r.Host {
+		return true
+	}
+
+	for i := range app.config.cors.trustedOrigins {
+		if origin == app.config.cors.trustedOrigins[i] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// GET method with /v1/tokens/csrf endpoint to retrieve the CSRF token of the
+// current session, for example after being redirected back by a provider
+func (app *application) showCSRFTokenHandler(w http.ResponseWriter, r *http.Request) {
+	session := app.contextGetSession(r)
+	if session == nil {
+		app.notFoundResponse(w, r)
+
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"csrf_token": app.csrfToken(session.ID)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// use the configured secret or generate a random one, the CSRF tokens of
+// a random secret stop working when the application is restarted
+func newCSRFKey(secret string, logger *jsonlog.Logger) ([]byte, error) {
+	if secret != "" {
+		if len(secret) < 32 {
+			return nil, errors.New("csrf key must be at least 32 bytes long")
+		}
+
+		return []byte(secret), nil
+	}
+
+	key := make([]byte, 32)
+
+	_, err := rand.Read(key)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.PrintInfo("no csrf key configured, using a random one", nil)
+
+	return key, nil
+}
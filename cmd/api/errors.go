@@ -2,7 +2,10 @@ package main
 
 import (
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 // logError() is a generic helper method for logging error message
@@ -11,6 +14,7 @@ func (app *application) logError(r *http.Request, err error) {
 	app.logger.PrintError(err, map[string]string{
 		"request_method": r.Method,
 		"request_url":    r.URL.String(),
+		"client_ip":      app.contextGetClientIP(r),
 	})
 }
 
@@ -60,7 +64,19 @@ func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Requ
 	app.errorResponse(w, r, http.StatusConflict, message)
 }
 
-func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request) {
+func (app *application) editWindowExpiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the edit window for this resource has expired."
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+
 	message := "rate limit exceeded."
 	app.errorResponse(w, r, http.StatusTooManyRequests, message)
 }
@@ -77,6 +93,24 @@ func (app *application) invalidEmailOrPasswordResponse(w http.ResponseWriter, r
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
 }
 
+func (app *application) invalidSecondFactorResponse(w http.ResponseWriter, r *http.Request) {
+	message := "invalid two-factor authentication code."
+
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+func (app *application) loginLockedResponse(w http.ResponseWriter, r *http.Request, until time.Time) {
+	retryAfter := int(math.Ceil(time.Until(until).Seconds()))
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+
+	message := "too many failed login attempts, please try again later."
+	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+}
+
 func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("WWW-Authenticate", "Bearer") // inform the client to authenticate using bearer token
 
@@ -89,11 +123,36 @@ func (app *application) authenticationRequiredResponse(w http.ResponseWriter, r
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
 }
 
+func (app *application) sessionRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "you must be logged in to access this resource, API keys can't be used."
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
 func (app *application) inactiveAccountResponse(w http.ResponseWriter, r *http.Request) {
 	message := "your user account must be activated to access this resource."
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
 
+func (app *application) disabledAccountResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account has been disabled."
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (app *application) unverifiedEmailResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the email address of your account with the provider must be verified."
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (app *application) invitationRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "registration requires an invitation."
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (app *application) invalidCSRFTokenResponse(w http.ResponseWriter, r *http.Request) {
+	message := "invalid or missing CSRF token."
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
 func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
 	message := "your user account doesn't have the necessary permissions to access this resource."
 	app.errorResponse(w, r, http.StatusForbidden, message)
@@ -9,7 +9,9 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
+	"api.cinevie.jpranata.tech/internal/data"
 	"api.cinevie.jpranata.tech/internal/validator"
 
 	"github.com/julienschmidt/httprouter"
@@ -27,6 +29,25 @@ func (app *application) readIDParam(r *http.Request) (int64, error) {
 	return id, nil
 }
 
+// read a non-numeric parameter like a permission code or a role name
+func (app *application) readStringParam(r *http.Request, key string) string {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	return params.ByName(key)
+}
+
+// read the slug parameter and normalize it the same way tag names are
+func (app *application) readSlugParam(r *http.Request) (string, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	slug := data.Slugify(params.ByName("slug"))
+	if slug == "" {
+		return "", errors.New("invalid slug parameter")
+	}
+
+	return slug, nil
+}
+
 // wrap the encoded JSON with parent key name of data
 // it's a self documenting, clarity about what data is
 // about and mitigate a security vulnerability in older browser
@@ -187,3 +208,112 @@ func (app *application) background(fn func()) {
 		fn()
 	}()
 }
+
+// returns the authentication token sent either in the session_token cookie or
+// in the "Authorization: Bearer <token>" header, when the request carries both
+// the configured precedence decides which one is used, an empty string
+// means the request has no token at all, fromCookie tells where it came from
+func (app *application) readAuthenticationToken(r *http.Request) (token string, fromCookie bool, err error) {
+	var cookieToken, bearerToken string
+
+	cookie, err := r.Cookie("session_token")
+	if err == nil {
+		cookieToken = cookie.Value
+	}
+
+	authorizationHeader := r.Header.Get("Authorization")
+	if authorizationHeader != "" {
+		// expect the header to be in the format "Bearer <token>",
+		// the scheme is case-insensitive
+		headerParts := strings.Split(authorizationHeader, " ")
+		if len(headerParts) == 2 && strings.EqualFold(headerParts[0], "Bearer") && headerParts[1] != "" {
+			bearerToken = headerParts[1]
+		} else if cookieToken == "" || app.config.auth.tokenPrecedence != "cookie" {
+			// a header the app can't parse (like one added by a proxy)
+			// is ignored when the cookie wins anyway
+			return "", false, errors.New("invalid authorization header")
+		}
+	}
+
+	if app.config.auth.tokenPrecedence == "bearer" && bearerToken != "" {
+		return bearerToken, false, nil
+	}
+
+	if cookieToken != "" {
+		return cookieToken, true, nil
+	}
+
+	return bearerToken, false, nil
+}
+
+// send the access token in the session_token cookie and the refresh token in
+// the refresh_token cookie which is only sent back to the refresh endpoint
+func (app *application) setTokenCookies(w http.ResponseWriter, accessToken, refreshToken *data.Token) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "session_token",
+		Value:    accessToken.Plaintext,
+		Expires:  accessToken.Expiry,
+		Secure:   true,
+		SameSite: http.SameSiteNoneMode,
+		HttpOnly: true,
+		Path:     "/",
+	})
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     "refresh_token",
+		Value:    refreshToken.Plaintext,
+		Expires:  refreshToken.Expiry,
+		Secure:   true,
+		SameSite: http.SameSiteNoneMode,
+		HttpOnly: true,
+		Path:     "/v1/tokens/refresh",
+	})
+}
+
+// send empty and already expired cookies so the browser removes them
+func (app *application) clearTokenCookies(w http.ResponseWriter) {
+	app.clearSessionTokenCookie(w)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     "refresh_token",
+		Value:    "",
+		Expires:  time.Now(),
+		Secure:   true,
+		SameSite: http.SameSiteNoneMode,
+		HttpOnly: true,
+		Path:     "/v1/tokens/refresh",
+	})
+}
+
+// remove only the access token, the refresh token might still be valid
+// when the access token has just been replaced by another tab
+func (app *application) clearSessionTokenCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "session_token",
+		Value:    "",
+		Expires:  time.Now(),
+		Secure:   true,
+		SameSite: http.SameSiteNoneMode,
+		HttpOnly: true,
+		Path:     "/",
+	})
+}
+
+// check whether another email of the kind could be sent to the address, the
+// addresses are throttled whether or not they're registered
+func (app *application) allowEmail(kind, email string) bool {
+	key := kind + ":" + strings.ToLower(email)
+
+	allowed, err := app.models.EmailThrottles.Allow(key, app.config.emails.throttleLimit, app.config.emails.throttleWindow)
+	if err != nil {
+		app.logger.PrintError(err, nil)
+
+		return false
+	}
+
+	if !allowed {
+		app.logger.PrintInfo("email throttled", map[string]string{"kind": kind})
+	}
+
+	return allowed
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"api.cinevie.jpranata.tech/internal/data"
+	"api.cinevie.jpranata.tech/internal/validator"
+)
+
+// GET method with /v1/invitations endpoint to list the invitations sent by the current user
+func (app *application) listInvitationsHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	invitations, err := app.models.Invitations.GetAllForInviter(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"invitations": invitations}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// POST method with /v1/invitations endpoint to invite an email address to register,
+// the invite code is only sent to the invitee by email
+func (app *application) createInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email       string     `json:"email"`
+		Roles       []string   `json:"roles"`
+		Permissions []string   `json:"permissions"`
+		Expiry      *time.Time `json:"expiry"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+
+		return
+	}
+
+	// the inviter's name is only in the complete record in the jwt mode
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+
+		return
+	}
+
+	invitation := &data.Invitation{
+		Email:       input.Email,
+		InvitedBy:   &user.ID,
+		Roles:       input.Roles,
+		Permissions: input.Permissions,
+		Expiry:      time.Now().Add(7 * 24 * time.Hour),
+	}
+
+	if invitation.Roles == nil {
+		invitation.Roles = []string{}
+	}
+
+	if invitation.Permissions == nil {
+		invitation.Permissions = data.Permissions{}
+	}
+
+	if input.Expiry != nil {
+		invitation.Expiry = *input.Expiry
+	}
+
+	roles, err := app.models.Roles.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+
+		return
+	}
+
+	v := validator.New()
+
+	// the invitee can't receive any permission the inviter doesn't have,
+	// neither directly nor through a role
+	permissions := app.contextGetPermissions(r)
+
+	for _, code := range invitation.Permissions {
+		v.Check(permissions.Include(code), "permissions", "must only contain permissions you have been granted.")
+	}
+
+	for _, name := range invitation.Roles {
+		var role *data.Role
+
+		for _, existing := range roles {
+			if existing.Name == name {
+				role = existing
+
+				break
+			}
+		}
+
+		if role == nil {
+			v.AddError("roles", "must only contain existing roles.")
+
+			continue
+		}
+
+		for _, code := range role.Permissions {
+			v.Check(permissions.Include(code), "roles", "must only contain roles whose permissions you have been granted.")
+		}
+	}
+
+	if data.ValidateInvitation(v, invitation); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+
+		return
+	}
+
+	err = app.models.Invitations.Insert(invitation)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+
+		return
+	}
+
+	code := invitation.Plaintext
+
+	app.background(func() {
+		data := map[string]interface{}{
+			"inviteCode":  code,
+			"inviterName": user.Name,
+			"email":       invitation.Email,
+			"expiry":      invitation.Expiry.UTC().Format(time.RFC1123),
+		}
+
+		err := app.mailer.Send(invitation.Email, "user_invitation.tmpl", data)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+
+	// the code is only known by the invitee
+	invitation.Plaintext = ""
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"invitation": invitation}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// DELETE method with /v1/invitations/:id endpoint to revoke a pending invitation
+func (app *application) deleteInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	err = app.models.Invitations.Delete(id, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "invitation successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// check the invite code the user registers with, the invitation must have been
+// sent to the same email address, the response has already been sent when it returns false
+func (app *application) checkInvitation(w http.ResponseWriter, r *http.Request, v *validator.Validator, code, email string) (*data.Invitation, bool) {
+	invitation, err := app.models.Invitations.GetForCode(code)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("invite_code", "invalid or expired invite code.")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+
+		return nil, false
+	}
+
+	if !strings.EqualFold(invitation.Email, email) {
+		v.AddError("invite_code", "invalid or expired invite code.")
+		app.failedValidationResponse(w, r, v.Errors)
+
+		return nil, false
+	}
+
+	return invitation, true
+}
+
+// mark the invitation as accepted by the registered user and grant the roles
+// and permissions of the invitation, the user is deleted again when the
+// invitation has been accepted by someone else in the meantime
+func (app *application) acceptInvitation(w http.ResponseWriter, r *http.Request, v *validator.Validator, invitation *data.Invitation, user *data.User) {
+	err := app.models.Invitations.Accept(invitation.ID, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			err = app.models.Users.Delete(user.ID)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+
+				return
+			}
+
+			v.AddError("invite_code", "invalid or expired invite code.")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	if len(invitation.Roles) > 0 {
+		err = app.models.Roles.AddForUser(user.ID, invitation.Roles...)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+
+			return
+		}
+	}
+
+	if len(invitation.Permissions) > 0 {
+		err = app.models.Permissions.AddForUser(user.ID, invitation.Permissions...)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+
+			return
+		}
+	}
+
+	// the user is activated already so they're able to log in right away
+	err = app.writeJSON(w, http.StatusCreated, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// launch the goroutines which periodically run the maintenance tasks
+func (app *application) startJobs() {
+	go func() {
+		for {
+			app.deleteScheduledUsers()
+			app.deleteExpiredSessions()
+
+			time.Sleep(time.Hour)
+		}
+	}()
+
+	// the signed tokens are checked against the in-memory revocation list
+	// which must be loaded before serving the first request
+	if app.jwtKeys != nil {
+		app.syncRevokedSessions()
+
+		go func() {
+			for {
+				time.Sleep(app.config.auth.revocationSyncInterval)
+
+				app.syncRevokedSessions()
+			}
+		}()
+	}
+}
+
+// permanently delete the users whose deletion grace period is over
+func (app *application) deleteScheduledUsers() {
+	// recover any panic by logging the message instead of terminating application
+	defer func() {
+		if err := recover(); err != nil {
+			app.logger.PrintError(fmt.Errorf("%s", err), nil)
+		}
+	}()
+
+	deleted, err := app.models.Users.DeleteScheduled(time.Now().Add(-app.config.users.deletionGracePeriod))
+	if err != nil {
+		app.logger.PrintError(err, nil)
+
+		return
+	}
+
+	if deleted > 0 {
+		app.logger.PrintInfo("deleted scheduled users", map[string]string{
+			"count": strconv.FormatInt(deleted, 10),
+		})
+	}
+}
+
+// delete the sessions which don't have any unexpired token left
+func (app *application) deleteExpiredSessions() {
+	defer func() {
+		if err := recover(); err != nil {
+			app.logger.PrintError(fmt.Errorf("%s", err), nil)
+		}
+	}()
+
+	deleted, err := app.models.Sessions.DeleteExpired()
+	if err != nil {
+		app.logger.PrintError(err, nil)
+
+		return
+	}
+
+	if deleted > 0 {
+		app.logger.PrintInfo("deleted expired sessions", map[string]string{
+			"count": strconv.FormatInt(deleted, 10),
+		})
+	}
+
+	// the revoked sessions are only needed while their signed tokens could still be valid
+	_, err = app.models.Sessions.DeleteRevokedBefore(time.Now().Add(-app.config.auth.accessTokenTTL))
+	if err != nil {
+		app.logger.PrintError(err, nil)
+	}
+
+	// the failed logins which don't count anymore
+	err = app.models.LoginFailures.DeleteExpired(time.Now().Add(-loginFailureWindow))
+	if err != nil {
+		app.logger.PrintError(err, nil)
+	}
+
+	err = app.models.EmailThrottles.DeleteExpired(time.Now().Add(-app.config.emails.throttleWindow))
+	if err != nil {
+		app.logger.PrintError(err, nil)
+	}
+
+	// the authorization requests the providers never redirected back
+	err = app.models.Identities.DeleteExpiredStates()
+	if err != nil {
+		app.logger.PrintError(err, nil)
+	}
+}
+
+// load the sessions revoked within the lifetime of an access token
+func (app *application) syncRevokedSessions() {
+	defer func() {
+		if err := recover(); err != nil {
+			app.logger.PrintError(fmt.Errorf("%s", err), nil)
+		}
+	}()
+
+	ids, err := app.models.Sessions.GetRevokedSince(time.Now().Add(-app.config.auth.accessTokenTTL))
+	if err != nil {
+		app.logger.PrintError(err, nil)
+
+		return
+	}
+
+	app.revocations.replace(ids)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"api.cinevie.jpranata.tech/internal/data"
+)
+
+// the failed logins older than the window don't count anymore
+const loginFailureWindow = 24 * time.Hour
+
+// the email addresses are counted whether or not they belong to a user
+// so the lockout doesn't reveal which addresses are registered
+func loginFailureEmailKey(email string) string {
+	return "email:" + strings.ToLower(email)
+}
+
+func loginFailureIPKey(ip string) string {
+	return "ip:" + ip
+}
+
+// send the 429 response and return false when either the email address or
+// the IP address of the request is still locked out
+func (app *application) checkLoginLockout(w http.ResponseWriter, r *http.Request, email string) bool {
+	now := time.Now()
+
+	for _, key := range []string{loginFailureEmailKey(email), loginFailureIPKey(app.contextGetClientIP(r))} {
+		failure, err := app.models.LoginFailures.Get(key)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				continue
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+
+			return false
+		}
+
+		if failure.IsLocked(now) {
+			app.loginLockedResponse(w, r, *failure.LockedUntil)
+
+			return false
+		}
+	}
+
+	return true
+}
+
+// count the failed login of the email and IP address, the email address has to
+// wait exponentially longer after the third failure and is locked out once it
+// reaches the threshold, the user is notified when their account is locked out
+func (app *application) recordLoginFailure(r *http.Request, email string, user *data.User) error {
+	emailKey := loginFailureEmailKey(email)
+
+	failures, err := app.models.LoginFailures.Record(emailKey, loginFailureWindow)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case failures >= app.config.lockout.maxFailures:
+		err = app.models.LoginFailures.Lock(emailKey, time.Now().Add(app.config.lockout.duration))
+		if err != nil {
+			return err
+		}
+
+		if failures == app.config.lockout.maxFailures && user != nil {
+			app.sendLockoutNotice(r, user)
+		}
+	case failures >= 3:
+		// clamp the exponent so the shift can't overflow, 2^30 seconds
+		// is way beyond any sensible lockout duration anyway
+		exponent := failures - 3
+		if exponent > 30 {
+			exponent = 30
+		}
+
+		backoff := time.Second << uint(exponent)
+		if backoff > app.config.lockout.duration {
+			backoff = app.config.lockout.duration
+		}
+
+		err = app.models.LoginFailures.Lock(emailKey, time.Now().Add(backoff))
+		if err != nil {
+			return err
+		}
+	}
+
+	// the IP addresses might be shared so they're only locked out
+	// after a lot more failures and without the backoff
+	ipKey := loginFailureIPKey(app.contextGetClientIP(r))
+
+	failures, err = app.models.LoginFailures.Record(ipKey, loginFailureWindow)
+	if err != nil {
+		return err
+	}
+
+	if failures >= app.config.lockout.ipMaxFailures {
+		return app.models.LoginFailures.Lock(ipKey, time.Now().Add(app.config.lockout.duration))
+	}
+
+	return nil
+}
+
+// forget the failed logins of the email address once the user logs in
+func (app *application) clearLoginFailures(email string) error {
+	_, err := app.models.LoginFailures.Delete(loginFailureEmailKey(email))
+
+	return err
+}
+
+// email the user that their account has been locked out
+func (app *application) sendLockoutNotice(r *http.Request, user *data.User) {
+	ip := app.contextGetClientIP(r)
+
+	app.background(func() {
+		data := map[string]interface{}{
+			"userName":        user.Name,
+			"ip":              ip,
+			"lockoutDuration": app.config.lockout.duration.String(),
+		}
+
+		err := app.mailer.Send(user.Email, "user_account_locked.tmpl", data)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+}
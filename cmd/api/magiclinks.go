@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"api.cinevie.jpranata.tech/internal/data"
+	"api.cinevie.jpranata.tech/internal/validator"
+)
+
+// POST method with /v1/tokens/magic-link endpoint to email a single-use login link,
+// the response is the same whether or not the email address belongs to a user
+func (app *application) createMagicLinkTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+
+		return
+	}
+
+	// the user is looked up in the background so neither the response
+	// nor its timing reveals whether the email address is registered
+	app.background(func() {
+		if !app.allowEmail(data.ScopeMagicLink, input.Email) {
+			return
+		}
+
+		user, err := app.models.Users.GetByEmail(input.Email)
+		if err != nil {
+			if !errors.Is(err, data.ErrRecordNotFound) {
+				app.logger.PrintError(err, nil)
+			}
+
+			return
+		}
+
+		if !user.Activated || user.Disabled {
+			return
+		}
+
+		// only the latest link is valid
+		err = app.models.Tokens.DeleteAllForUser(data.ScopeMagicLink, user.ID)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+
+			return
+		}
+
+		token, err := app.models.Tokens.New(user.ID, 15*time.Minute, data.ScopeMagicLink)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+
+			return
+		}
+
+		data := map[string]interface{}{
+			"magicLinkToken": token.Plaintext,
+			"magicLinkURL":   app.config.auth.magicLinkURL,
+			"userName":       user.Name,
+		}
+
+		err = app.mailer.Send(user.Email, "token_magic_link.tmpl", data)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+
+	env := envelope{"message": "if the email address belongs to an activated account, a login link would be sent to it."}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// POST method with /v1/tokens/magic-link/exchange endpoint to log in with the
+// token of the emailed link, the tokens are sent in the cookies like the password login
+func (app *application) exchangeMagicLinkTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Token string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, input.Token); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+
+		return
+	}
+
+	var user *data.User
+
+	// the link can only be used once
+	userID, err := app.models.Tokens.Consume(data.ScopeMagicLink, input.Token)
+	if err == nil {
+		user, err = app.models.Users.Get(userID)
+	}
+
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired magic link token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	// the link replaces the password only, not the second factor
+	accessToken, refreshToken, ok := app.completeLogin(w, r, user, true)
+	if !ok {
+		return
+	}
+
+	app.setTokenCookies(w, accessToken, refreshToken)
+
+	env := envelope{"authentication": "Success", "csrf_token": app.csrfToken(*refreshToken.SessionID)}
+
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
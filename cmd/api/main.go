@@ -3,18 +3,23 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"expvar"
 	"flag"
 	"fmt"
+	"net"
 	"os"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"api.cinevie.jpranata.tech/internal/data"
 	"api.cinevie.jpranata.tech/internal/jsonlog"
+	"api.cinevie.jpranata.tech/internal/jwt"
 	"api.cinevie.jpranata.tech/internal/mailer"
+	"api.cinevie.jpranata.tech/internal/oidc"
 
 	// pq driver would register itself with database/sql
 	// aliasing import to blank identifier(-) to stop compiler complaining
@@ -44,10 +49,18 @@ type config struct {
 
 	// a new limiter struct containing fields for request-per-second, burst values,
 	// and a boolean field to indicate that rate limiter is enabled or disabled
+	// the anonymous clients are limited by IP address and the users by their id,
+	// the logins, the account emails, the comments and the movie reads have their own policy
 	limiter struct {
 		rps     float64
 		burst   int
 		enabled bool
+
+		user     rateLimitPolicy
+		auth     rateLimitPolicy
+		accounts rateLimitPolicy
+		comments rateLimitPolicy
+		reads    rateLimitPolicy
 	}
 
 	smtp struct {
@@ -61,15 +74,125 @@ type config struct {
 	cors struct {
 		trustedOrigins []string
 	}
+
+	// the reverse proxies whose X-Forwarded-For and X-Real-IP headers are
+	// trusted, the headers are ignored when the peer isn't one of them
+	proxies struct {
+		trusted []*net.IPNet
+	}
+
+	// the security headers of every response, an empty value leaves the
+	// header out, the metrics have their own caching policy
+	headers struct {
+		contentSecurityPolicy   string
+		strictTransportSecurity string
+		referrerPolicy          string
+		permissionsPolicy       string
+		cacheControl            string
+		metricsCacheControl     string
+	}
+
+	// the secret the CSRF tokens are derived from, a random one is
+	// generated when it's empty which only works for a single instance
+	csrf struct {
+		key string
+	}
+
+	// which authentication token wins when a request carries both
+	// the session_token cookie and the Authorization header
+	// the access tokens are short-lived and renewed with the refresh token
+	// whose expiry slides forward on every refresh
+	auth struct {
+		tokenPrecedence string
+		accessTokenTTL  time.Duration
+		refreshTokenTTL time.Duration
+
+		// a refresh token used again within the grace period is only refused
+		// rather than taken for a stolen one, the tabs of a browser might refresh at once
+		refreshReuseGrace time.Duration
+
+		// in the jwt mode the access tokens are signed and verified without
+		// a database lookup, the revoked sessions are synchronized periodically
+		mode                   string
+		jwtKeys                string
+		revocationSyncInterval time.Duration
+
+		// the frontend page the magic link points to, the emailed
+		// token has to be exchanged manually when it's empty
+		magicLinkURL string
+	}
+
+	// the OpenID Connect providers the users are able to log in with,
+	// the user is sent to the success URL once logged in or to complete
+	// the second factor, JSON is returned instead when it's empty
+	oidc struct {
+		providers       string
+		redirectBaseURL string
+		successURL      string
+	}
+
+	// how many activation, password reset and login link emails
+	// an email address receives within the window at most
+	emails struct {
+		throttleLimit  int
+		throttleWindow time.Duration
+	}
+
+	// the failed logins of an email address are slowed down with an exponential
+	// backoff until it's locked out, the IP addresses are only locked out
+	lockout struct {
+		maxFailures   int
+		ipMaxFailures int
+		duration      time.Duration
+	}
+
+	// the requirements of the new passwords, the breached passwords
+	// are only screened when the list file is given
+	// the older hashes are upgraded to the hash algorithm and cost on login,
+	// the argon2id hashes calculated at once share the memory budget
+	passwords struct {
+		minLength    int
+		minEntropy   float64
+		breachedList string
+
+		hashAlgorithm      string
+		bcryptCost         int
+		argon2Memory       uint
+		argon2Iterations   uint
+		argon2Parallelism  uint
+		argon2MemoryBudget uint
+	}
+
+	// how long the users' permissions are cached in memory,
+	// zero disables the cache
+	permissions struct {
+		cacheTTL time.Duration
+	}
+
+	// how long a user could still cancel their account deletion
+	// before it being permanently deleted, in the invite mode
+	// the users can only register with an invitation
+	users struct {
+		deletionGracePeriod time.Duration
+		defaultRole         string
+		registration        string
+	}
 }
 
 // application dependencies
 type application struct {
-	config config
-	logger *jsonlog.Logger
-	models data.Models
-	mailer mailer.Mailer
-	wg     sync.WaitGroup
+	config      config
+	logger      *jsonlog.Logger
+	models      data.Models
+	mailer      mailer.Mailer
+	permissions *permissionCache
+	limiter     *rateLimiter
+	jwtKeys     *jwt.KeySet
+	revocations *revocationList
+	oidc        map[string]*oidc.Provider
+	passwords   data.PasswordPolicy
+	csrfKey     []byte
+	wg          sync.WaitGroup
 }
 
 func main() {
@@ -88,9 +211,19 @@ func main() {
 	flag.StringVar(&cfg.db.maxIdleTime, "db-max-idle-time", "15m", "PostgreSQL max connection idle time.")
 
 	// read rate limiter setting from command line
-	flag.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter maximum request per second.")
-	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst.")
+	flag.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter maximum request per second for each anonymous IP address.")
+	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst for each anonymous IP address.")
 	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter.")
+	flag.Float64Var(&cfg.limiter.user.rps, "limiter-user-rps", 5, "Rate limiter maximum request per second for each user.")
+	flag.IntVar(&cfg.limiter.user.burst, "limiter-user-burst", 10, "Rate limiter maximum burst for each user.")
+	flag.Float64Var(&cfg.limiter.auth.rps, "limiter-auth-rps", 0.1, "Rate limiter maximum logins per second for each IP address.")
+	flag.IntVar(&cfg.limiter.auth.burst, "limiter-auth-burst", 5, "Rate limiter maximum burst of logins for each IP address.")
+	flag.Float64Var(&cfg.limiter.accounts.rps, "limiter-accounts-rps", 0.02, "Rate limiter maximum registrations and account emails per second for each IP address.")
+	flag.IntVar(&cfg.limiter.accounts.burst, "limiter-accounts-burst", 3, "Rate limiter maximum burst of registrations and account emails for each IP address.")
+	flag.Float64Var(&cfg.limiter.comments.rps, "limiter-comments-rps", 0.05, "Rate limiter maximum comments per second for each user.")
+	flag.IntVar(&cfg.limiter.comments.burst, "limiter-comments-burst", 5, "Rate limiter maximum burst of comments for each user.")
+	flag.Float64Var(&cfg.limiter.reads.rps, "limiter-reads-rps", 10, "Rate limiter maximum movie reads per second for each user or IP address.")
+	flag.IntVar(&cfg.limiter.reads.burst, "limiter-reads-burst", 20, "Rate limiter maximum burst of movie reads for each user or IP address.")
 
 	// smtp server
 	flag.StringVar(&cfg.smtp.host, "smtp-host", "smtp.mailtrap.io", "SMTP host")
@@ -99,6 +232,60 @@ func main() {
 	flag.StringVar(&cfg.smtp.password, "smtp-password", "d795c443f1a147", "SMTP password")
 	flag.StringVar(&cfg.smtp.sender, "smtp-sender", "Cinevie <no-reply@cinevie.jpranata.tech>", "SMTP sender")
 
+	cfg.auth.tokenPrecedence = "cookie"
+	flag.Func("auth-token-precedence", "Authentication token used when both are sent (cookie | bearer), defaults to cookie.", func(val string) error {
+		if val != "cookie" && val != "bearer" {
+			return errors.New("must be either cookie or bearer")
+		}
+
+		cfg.auth.tokenPrecedence = val
+
+		return nil
+	})
+
+	flag.DurationVar(&cfg.auth.accessTokenTTL, "auth-access-token-ttl", 15*time.Minute, "Lifetime of the authentication (access) tokens.")
+	flag.DurationVar(&cfg.auth.refreshTokenTTL, "auth-refresh-token-ttl", 30*24*time.Hour, "Lifetime of the refresh tokens, extended on every refresh.")
+	flag.DurationVar(&cfg.auth.refreshReuseGrace, "auth-refresh-reuse-grace", 10*time.Second, "How long the reuse of a rotated refresh token is only refused before it revokes the session.")
+
+	cfg.auth.mode = "opaque"
+	flag.Func("auth-mode", "Authentication token mode (opaque | jwt), defaults to opaque.", func(val string) error {
+		if val != "opaque" && val != "jwt" {
+			return errors.New("must be either opaque or jwt")
+		}
+
+		cfg.auth.mode = val
+
+		return nil
+	})
+
+	flag.StringVar(&cfg.auth.jwtKeys, "auth-jwt-keys", os.Getenv("CINEVIE_JWT_KEYS"), "Signing keys of the jwt mode (space separated kid:base64-secret, the first one signs).")
+	flag.DurationVar(&cfg.auth.revocationSyncInterval, "auth-revocation-sync-interval", 5*time.Second, "How often the revoked sessions are loaded in the jwt mode.")
+	flag.StringVar(&cfg.auth.magicLinkURL, "auth-magic-link-url", "", "Frontend URL the emailed login links point to, the token is appended as the token query parameter.")
+
+	flag.StringVar(&cfg.oidc.providers, "oidc-providers", os.Getenv("CINEVIE_OIDC_PROVIDERS"), "OpenID Connect providers (space separated name|issuer|client_id|client_secret).")
+	flag.StringVar(&cfg.oidc.redirectBaseURL, "oidc-redirect-base-url", "http://localhost:4000", "Base URL of the API the providers redirect back to.")
+	flag.StringVar(&cfg.oidc.successURL, "oidc-success-url", "", "Frontend URL the user is redirected to after logging in with a provider.")
+
+	flag.IntVar(&cfg.emails.throttleLimit, "emails-throttle-limit", 3, "Maximum activation, password reset or login link emails of each kind an address receives within the window.")
+	flag.DurationVar(&cfg.emails.throttleWindow, "emails-throttle-window", time.Hour, "Window of the email throttling.")
+
+	flag.IntVar(&cfg.lockout.maxFailures, "lockout-max-failures", 10, "Failed logins before an account is locked out.")
+	flag.IntVar(&cfg.lockout.ipMaxFailures, "lockout-ip-max-failures", 100, "Failed logins before an IP address is locked out.")
+	flag.DurationVar(&cfg.lockout.duration, "lockout-duration", 15*time.Minute, "How long a locked out account or IP address is unable to log in.")
+
+	flag.IntVar(&cfg.passwords.minLength, "passwords-min-length", 8, "Minimum length of the new passwords.")
+	flag.Float64Var(&cfg.passwords.minEntropy, "passwords-min-entropy", 40, "Minimum estimated entropy in bits of the new passwords.")
+	flag.StringVar(&cfg.passwords.breachedList, "passwords-breached-list", "", "File of breached passwords or their SHA-1 hashes (one per line) the new passwords are screened against.")
+
+	flag.StringVar(&cfg.passwords.hashAlgorithm, "passwords-hash-algorithm", data.HashArgon2id, "Hash algorithm of the new passwords (argon2id | bcrypt).")
+	flag.IntVar(&cfg.passwords.bcryptCost, "passwords-bcrypt-cost", 12, "Cost of the bcrypt password hashes.")
+	flag.UintVar(&cfg.passwords.argon2Memory, "passwords-argon2-memory", 64*1024, "Memory in KiB of the argon2id password hashes.")
+	flag.UintVar(&cfg.passwords.argon2Iterations, "passwords-argon2-iterations", 3, "Iterations of the argon2id password hashes.")
+	flag.UintVar(&cfg.passwords.argon2Parallelism, "passwords-argon2-parallelism", 2, "Parallelism of the argon2id password hashes.")
+	flag.UintVar(&cfg.passwords.argon2MemoryBudget, "passwords-argon2-memory-budget", 256, "Memory in MiB the argon2id password hashes calculated at once use at most.")
+
+	flag.DurationVar(&cfg.permissions.cacheTTL, "permissions-cache-ttl", 30*time.Second, "How long the users' permissions are cached in memory (0 to disable).")
+
 	// cors
 	flag.Func("cors-trusted-origins", "Trusted CORS origins (space separated).", func(val string) error {
 		cfg.cors.trustedOrigins = strings.Fields(val)
@@ -106,6 +293,39 @@ func main() {
 		return nil
 	})
 
+	flag.StringVar(&cfg.headers.contentSecurityPolicy, "headers-csp", "default-src 'none'; frame-ancestors 'none'", "Content-Security-Policy header of the responses.")
+	flag.StringVar(&cfg.headers.strictTransportSecurity, "headers-hsts", "max-age=63072000; includeSubDomains", "Strict-Transport-Security header of the responses.")
+	flag.StringVar(&cfg.headers.referrerPolicy, "headers-referrer-policy", "no-referrer", "Referrer-Policy header of the responses.")
+	flag.StringVar(&cfg.headers.permissionsPolicy, "headers-permissions-policy", "camera=(), geolocation=(), microphone=(), payment=(), usb=()", "Permissions-Policy header of the responses.")
+	flag.StringVar(&cfg.headers.cacheControl, "headers-cache-control", "no-store", "Cache-Control header of the API responses.")
+	flag.StringVar(&cfg.headers.metricsCacheControl, "headers-metrics-cache-control", "no-cache, private", "Cache-Control header of the metrics responses.")
+
+	flag.Func("trusted-proxies", "Trusted reverse proxies (space separated CIDRs or IP addresses).", func(val string) error {
+		proxies, err := parseTrustedProxies(val)
+		if err != nil {
+			return err
+		}
+
+		cfg.proxies.trusted = proxies
+
+		return nil
+	})
+
+	flag.StringVar(&cfg.csrf.key, "csrf-key", os.Getenv("CINEVIE_CSRF_KEY"), "Secret the CSRF tokens are derived from (at least 32 bytes).")
+
+	flag.StringVar(&cfg.users.defaultRole, "users-default-role", "viewer", "Role assigned to newly registered users.")
+	cfg.users.registration = "open"
+	flag.Func("users-registration", "Registration mode (open | invite), defaults to open.", func(val string) error {
+		if val != "open" && val != "invite" {
+			return errors.New("must be open or invite")
+		}
+
+		cfg.users.registration = val
+
+		return nil
+	})
+	flag.DurationVar(&cfg.users.deletionGracePeriod, "users-deletion-grace-period", 30*24*time.Hour, "Grace period before a deleted user account is permanently removed.")
+
 	// a new version boolean flag with the default value of false
 	displayVersion := flag.Bool("version", false, "Display version and exit.")
 
@@ -157,12 +377,88 @@ func main() {
 
 	// initialize Models struct passing in the connection pool as parameter
 	app := &application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
-		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		config:      cfg,
+		logger:      logger,
+		models:      data.NewModels(db),
+		mailer:      mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		permissions: newPermissionCache(cfg.permissions.cacheTTL),
+		limiter: newRateLimiter(
+			rateLimitPolicy{rps: cfg.limiter.rps, burst: cfg.limiter.burst},
+			cfg.limiter.user,
+			rateLimitRoutes(cfg),
+		),
+		revocations: newRevocationList(),
+	}
+
+	// the new users would silently get no permissions with a missing role
+	roles, err := app.models.Roles.GetAll()
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	defaultRoleFound := false
+
+	for _, role := range roles {
+		if role.Name == cfg.users.defaultRole {
+			defaultRoleFound = true
+		}
+	}
+
+	if !defaultRoleFound {
+		logger.PrintFatal(fmt.Errorf("default role %q doesn't exist", cfg.users.defaultRole), nil)
+	}
+
+	// the signing keys are only needed in the jwt mode
+	if cfg.auth.mode == "jwt" {
+		app.jwtKeys, err = jwt.ParseKeySet(cfg.auth.jwtKeys)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+	}
+
+	err = data.SetPasswordHashParams(data.HashParams{
+		Algorithm:  cfg.passwords.hashAlgorithm,
+		BcryptCost: cfg.passwords.bcryptCost,
+		Argon2: data.Argon2Params{
+			Memory:      uint32(cfg.passwords.argon2Memory),
+			Iterations:  uint32(cfg.passwords.argon2Iterations),
+			Parallelism: uint8(cfg.passwords.argon2Parallelism),
+		},
+		MemoryBudget: uint32(cfg.passwords.argon2MemoryBudget * 1024),
+	})
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	app.passwords = data.PasswordPolicy{
+		MinLength:  cfg.passwords.minLength,
+		MinEntropy: cfg.passwords.minEntropy,
+	}
+
+	if cfg.passwords.breachedList != "" {
+		app.passwords.Breached, err = data.LoadBreachedPasswords(cfg.passwords.breachedList)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+
+		logger.PrintInfo("breached password list loaded", map[string]string{
+			"count": strconv.Itoa(app.passwords.Breached.Len()),
+		})
+	}
+
+	app.csrfKey, err = newCSRFKey(cfg.csrf.key, logger)
+	if err != nil {
+		logger.PrintFatal(err, nil)
 	}
 
+	app.oidc, err = parseOIDCProviders(cfg)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	// start the periodic background jobs
+	app.startJobs()
+
 	err = app.serve()
 	// print FATAL level and exit
 	// fix panic: runtime error: invalid memory address or nil pointer dereference
@@ -6,15 +6,13 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
-	"sync"
 	"time"
 
 	"api.cinevie.jpranata.tech/internal/data"
+	"api.cinevie.jpranata.tech/internal/jwt"
 	"api.cinevie.jpranata.tech/internal/validator"
 
 	"github.com/felixge/httpsnoop"
-	"github.com/tomasen/realip"
-	"golang.org/x/time/rate"
 )
 
 func (app *application) recoverPanic(next http.Handler) http.Handler {
@@ -42,103 +40,145 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 	})
 }
 
+// count the request against the bucket of the authenticated user or the
+// client's IP address, the routes with their own policy have separate buckets
+// so the logins don't use up the browsing and the other way around
 func (app *application) rateLimit(next http.Handler) http.Handler {
-	// struct to hold the rate limiter and last seen time for each client
-	type client struct {
-		limiter  *rate.Limiter
-		lastSeen time.Time
-	}
-
-	// a mutex and a map to hold the clients' IP addresses and rate limiters
-	var (
-		mu sync.Mutex
-		// update the map so the values are pointers to a client struct
-		clients = make(map[string]*client)
-	)
-
-	// launch goroutine in the background which removes old entries from the clients
-	// once every minute
-	go func() {
-		for {
-			time.Sleep(time.Minute)
-
-			// lock the mutex to prevent any rate limiter checks from happening
-			// while the cleanup is taking place
-			mu.Lock()
-
-			// loop through the entire clients, if they haven't been seen within
-			// the last three minutes, delete the corresponding entry from the map
-			for ip, client := range clients {
-				if time.Since(client.lastSeen) > 3*time.Minute {
-					delete(clients, ip)
-				}
-			}
-
-			// unlock when the cleanup check is complete
-			mu.Unlock()
-		}
-	}()
-
-	// return closure which 'close over' the limiter variable
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// only process rate limiter if it is enabled
 		if app.config.limiter.enabled {
-			// use the realip.FromRequest() function to get the client's real IP address
-			ip := realip.FromRequest(r)
+			user := app.contextGetUser(r)
 
-			// lock the mutex to prevent this code from being executed concurrently
-			mu.Lock()
-
-			if _, found := clients[ip]; !found {
-				// use the request per second and burst value from config struct
-				clients[ip] = &client{limiter: rate.NewLimiter(rate.Limit(app.config.limiter.rps), app.config.limiter.burst)}
+			// the users behind the same NAT don't share the bucket once logged in
+			identity := "ip:" + app.contextGetClientIP(r)
+			if !user.IsAnonymous() {
+				identity = "user:" + strconv.FormatInt(user.ID, 10)
 			}
 
-			// update the last seen time for the client
-			clients[ip].lastSeen = time.Now()
-
-			// call Allow() method on the rate limiter for the current IP address
-			// if the request isn't allowed, unlock the mutex and send a 429 Too Many Request
-			if !clients[ip].limiter.Allow() {
-				mu.Unlock()
-				app.rateLimitExceededResponse(w, r)
+			name, policy := app.limiter.policy(r, user.IsAnonymous())
 
+			if !app.allowRequest(w, r, name+":"+identity, policy) {
 				return
 			}
-
-			// unlock mutex before calling the next handler in the chain
-			mu.Unlock()
 		}
 
 		next.ServeHTTP(w, r)
 	})
 }
 
+// the failed authentications count against the client's IP address like the
+// anonymous requests so the tokens can't be guessed without any limit
+func (app *application) failedAuthenticationResponse(w http.ResponseWriter, r *http.Request) {
+	if app.config.limiter.enabled {
+		if !app.allowRequest(w, r, "anonymous:ip:"+app.contextGetClientIP(r), app.limiter.anonymous) {
+			return
+		}
+	}
+
+	app.invalidAuthenticationTokenResponse(w, r)
+}
+
 func (app *application) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-	  	token, err := r.Cookie("session_token")
+		// the response varies depending on the Authorization header
+		w.Header().Add("Vary", "Authorization")
+
+		token, fromCookie, err := app.readAuthenticationToken(r)
 		if err != nil {
+			app.failedAuthenticationResponse(w, r)
+
+			return
+		}
+
+		anonymous := func() {
 			r = app.contextSetUser(r, data.AnonymousUser)
+			r = app.contextSetPermissions(r, data.Permissions{})
 			next.ServeHTTP(w, r)
+		}
+
+		// an expired or revoked cookie is removed and the request carries on
+		// anonymously, otherwise the browser couldn't even log in again
+		invalidToken := func() {
+			if fromCookie {
+				app.clearSessionTokenCookie(w)
+				anonymous()
+
+				return
+			}
+
+			app.failedAuthenticationResponse(w, r)
+		}
+
+		// no token in either the cookie or the Authorization header
+		if token == "" {
+			anonymous()
 
 			return
 		}
 
-		// validate the token to make sure it is in sensible format
-		v := validator.New()
-		if data.ValidateTokenPlaintext(v, token.Value); !v.Valid() {
-			app.invalidAuthenticationTokenResponse(w, r)
+		// signed tokens are verified without any database lookup
+		if app.jwtKeys != nil && jwt.IsToken(token) {
+			claims, err := app.jwtKeys.Verify(token)
+			if err != nil || app.revocations.has(claims.SessionID) {
+				invalidToken()
+
+				return
+			}
+
+			user := &data.User{
+				ID:        claims.Subject,
+				Activated: claims.Activated,
+			}
+
+			r = app.contextSetUser(r, user)
+			r = app.contextSetPermissions(r, append(data.Permissions{}, claims.Permissions...))
+			r = app.contextSetSession(r, &data.Session{ID: claims.SessionID, UserID: user.ID, Current: true})
+			r = app.contextSetClaims(r, claims)
+
+			next.ServeHTTP(w, r)
 
 			return
 		}
 
-		// retrieve the details of the user associated with the authentication token
-		// notice that ScopeAuthentication as the first parameter are being used
-		user, err := app.models.Users.GetForToken(data.ScopeAuthentication, token.Value)
+		var user *data.User
+		var key *data.APIKey
+		var session *data.Session
+
+		v := validator.New()
+
+		// personal API keys are told apart from the authentication tokens by their prefix
+		if data.IsAPIKey(token) {
+			if data.ValidateAPIKeyPlaintext(v, token); !v.Valid() {
+				invalidToken()
+
+				return
+			}
+
+			// retrieve the details of the user who owns the API key
+			key, err = app.models.APIKeys.GetForKey(token)
+			if err == nil {
+				user, err = app.models.Users.Get(key.UserID)
+			}
+		} else {
+			// validate the token to make sure it is in sensible format
+			if data.ValidateTokenPlaintext(v, token); !v.Valid() {
+				invalidToken()
+
+				return
+			}
+
+			// retrieve the session and then the user associated with the authentication token
+			// notice that ScopeAuthentication as the first parameter are being used
+			session, err = app.models.Sessions.GetForToken(data.ScopeAuthentication, token)
+			if err == nil {
+				user, err = app.models.Users.Get(session.UserID)
+			}
+		}
+
 		if err != nil {
 			switch {
 			case errors.Is(err, data.ErrRecordNotFound):
-				app.invalidAuthenticationTokenResponse(w, r)
+				invalidToken()
 			default:
 				app.serverErrorResponse(w, r, err)
 			}
@@ -146,14 +186,142 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
+		// the sessions are deleted when the account is disabled
+		// but check anyway in case one slipped through
+		if user.Disabled {
+			app.disabledAccountResponse(w, r)
+
+			return
+		}
+
+		// load the user's permissions once for the whole request
+		permissions, err := app.userPermissions(user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+
+			return
+		}
+
+		if key != nil {
+			// the API key only carries the permissions chosen when it was created
+			// which the user must still hold
+			permissions = key.Permissions.Intersect(permissions)
+
+			err = app.models.APIKeys.Touch(key.ID, app.contextGetClientIP(r))
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+
+				return
+			}
+		}
+
+		if session != nil {
+			ip := app.contextGetClientIP(r)
+
+			// only record the activity once a minute to avoid a write on every request
+			if time.Since(session.LastSeenAt) > time.Minute || session.IP != ip {
+				err = app.models.Sessions.Touch(session.ID, ip)
+				if err != nil {
+					app.serverErrorResponse(w, r, err)
+
+					return
+				}
+			}
+
+			r = app.contextSetSession(r, session)
+		}
+
 		// add user information to the request context
 		r = app.contextSetUser(r, user)
+		r = app.contextSetPermissions(r, permissions)
 
 		// call the next handler in the chain
 		next.ServeHTTP(w, r)
 	})
 }
 
+// the state-changing requests authenticated by the session_token cookie or,
+// on the refresh endpoint, by the refresh_token cookie must send the CSRF token
+// of the session and come from a trusted origin, the requests with the
+// Authorization header are exempt since a browser can't add the header to a
+// cross-site request without a CORS preflight
+func (app *application) csrfProtect(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+
+			return
+		}
+
+		refreshCookie, err := r.Cookie("refresh_token")
+		refreshing := err == nil && r.URL.Path == "/v1/tokens/refresh"
+
+		if !refreshing {
+			// an invalid session_token cookie has already been dropped and the
+			// request carries on anonymously without any credentials
+			_, fromCookie, err := app.readAuthenticationToken(r)
+			if err != nil || !fromCookie || app.contextGetUser(r).IsAnonymous() {
+				next.ServeHTTP(w, r)
+
+				return
+			}
+		}
+
+		if !app.trustedRequestOrigin(r) {
+			app.invalidCSRFTokenResponse(w, r)
+
+			return
+		}
+
+		session := app.contextGetSession(r)
+
+		// the refresh endpoint carries no access token, the session is looked up
+		// by the refresh token without rotating it
+		if refreshing {
+			session, err = app.models.Sessions.GetForToken(data.ScopeRefresh, refreshCookie.Value)
+			if err != nil {
+				switch {
+				case errors.Is(err, data.ErrRecordNotFound):
+					// the token can't refresh anything, the handler rejects it
+					next.ServeHTTP(w, r)
+				default:
+					app.serverErrorResponse(w, r, err)
+				}
+
+				return
+			}
+		}
+
+		// a cookie always belongs to a session, a request without one is
+		// rejected rather than let through
+		if session == nil || !app.validCSRFToken(r, session.ID) {
+			app.invalidCSRFTokenResponse(w, r)
+
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// the account management is only open to the users who logged in, the API keys
+// can't be used to take over the account they belong to
+func (app *application) requireSession(next http.HandlerFunc) http.HandlerFunc {
+	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the requests authenticated with an API key carry no session
+		if app.contextGetSession(r) == nil {
+			app.sessionRequiredResponse(w, r)
+
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+
+	return app.requireAuthenticatedUser(fn)
+}
+
 // check if a user is not anonymous
 func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -192,16 +360,9 @@ func (app *application) requireActivatedUser(next http.HandlerFunc) http.Handler
 
 func (app *application) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
 	fn := func(w http.ResponseWriter, r *http.Request) {
-		// retrieve the user from request context
-		user := app.contextGetUser(r)
-
-		// get the slice codes of permissions for the user
-		permissions, err := app.models.Permissions.GetAllForUser(user.ID)
-		if err != nil {
-			app.serverErrorResponse(w, r, err)
-
-			return
-		}
+		// get the slice codes of permissions for the user which
+		// have been loaded by the authenticate middleware
+		permissions := app.contextGetPermissions(r)
 
 		// check if the slice includes the required permission
 		if !permissions.Include(code) {
@@ -219,6 +380,38 @@ func (app *application) requirePermission(code string, next http.HandlerFunc) ht
 	return app.requireActivatedUser(fn)
 }
 
+// set the security headers before the handlers so they're able to replace
+// them, they're on the error responses of the other middlewares too
+func (app *application) securityHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers := app.config.headers
+
+		cacheControl := headers.cacheControl
+		if r.URL.Path == "/metrics" {
+			cacheControl = headers.metricsCacheControl
+		}
+
+		values := map[string]string{
+			"Content-Security-Policy":   headers.contentSecurityPolicy,
+			"Strict-Transport-Security": headers.strictTransportSecurity,
+			"Referrer-Policy":           headers.referrerPolicy,
+			"Permissions-Policy":        headers.permissionsPolicy,
+			"Cache-Control":             cacheControl,
+		}
+
+		for name, value := range values {
+			if value != "" {
+				w.Header().Set(name, value)
+			}
+		}
+
+		// the responses are never meant to be sniffed into another content type
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (app *application) enableCORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// warn any caches that the response may be different
@@ -236,14 +429,16 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 			// loop and check if the request origin matches trusted list
 			for i := range app.config.cors.trustedOrigins {
 				if origin == app.config.cors.trustedOrigins[i] {
-		w.Header().Set("Access-Control-Allow-Origin", origin)
-		w.Header().Set("Access-Control-Allow-Credentials", "true")
+					w.Header().Set("Access-Control-Allow-Origin", origin)
+					w.Header().Set("Access-Control-Allow-Credentials", "true")
+					// let the frontend read how many requests are left
+					w.Header().Set("Access-Control-Expose-Headers", "RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset, Retry-After")
 					// if the request has the HTTP method OPTIONS and contains
 					// the "Access-Control-Request-Method" header treat it as
 					// a pre-flight request
 					if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
-						w.Header().Set("Access-Control-Allow-Headers", "Content-Type, withCredentials")
-						w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, GET, PUT, PATCH, DELETE")
+						w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, X-CSRF-Token, withCredentials")
+						w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, GET, POST, PUT, PATCH, DELETE")
 
 						// write the headers along with a 200 OK status and return from the middleware
 						// with no further action
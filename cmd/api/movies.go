@@ -260,6 +260,7 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 	var input struct {
 		Title  string
 		Genres []string
+		Tags   []string
 		data.Filters
 	}
 
@@ -272,6 +273,8 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 	// extract the title and genres using helper
 	input.Title = app.readString(qs, "title", "")
 	input.Genres = app.readCSV(qs, "genres", []string{})
+	// tags are matched by their normalized slug
+	input.Tags = data.SlugifyAll(app.readCSV(qs, "tags", []string{}))
 
 	// get the page and page_size as integers
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
@@ -290,7 +293,7 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 	}
 
 	// call GetAll() method to retrieve the movies and passing various filter parameters
-	movies, metadata, err := app.models.Movies.GetAll(input.Title, input.Genres, input.Filters)
+	movies, metadata, err := app.models.Movies.GetAll(input.Title, input.Genres, input.Tags, input.Filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 
@@ -0,0 +1,410 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"api.cinevie.jpranata.tech/internal/data"
+	"api.cinevie.jpranata.tech/internal/oidc"
+	"api.cinevie.jpranata.tech/internal/validator"
+)
+
+// how long the user has to log in with the provider
+const oidcStateTTL = 10 * time.Minute
+
+// the cookie holding the hash of the state of the login started in the browser
+const oidcStateCookie = "oidc_state"
+
+func oidcStateHash(state string) string {
+	hash := sha256.Sum256([]byte(state))
+
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+// parse the space separated name|issuer|client_id|client_secret entries of
+// the configured providers, each provider redirects back to its own callback
+func parseOIDCProviders(cfg config) (map[string]*oidc.Provider, error) {
+	providers := make(map[string]*oidc.Provider)
+
+	baseURL := strings.TrimSuffix(cfg.oidc.redirectBaseURL, "/")
+
+	for _, entry := range strings.Fields(cfg.oidc.providers) {
+		parts := strings.SplitN(entry, "|", 4)
+		if len(parts) != 4 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+			return nil, fmt.Errorf("invalid oidc provider %q", entry)
+		}
+
+		name := parts[0]
+
+		if _, exists := providers[name]; exists {
+			return nil, fmt.Errorf("duplicate oidc provider %q", name)
+		}
+
+		redirectURL := fmt.Sprintf("%s/v1/oidc/%s/callback", baseURL, url.PathEscape(name))
+
+		providers[name] = oidc.NewProvider(name, parts[1], parts[2], parts[3], redirectURL)
+	}
+
+	return providers, nil
+}
+
+// GET method with /v1/oidc/:provider/login endpoint which redirects the user
+// to the provider's authorization endpoint
+func (app *application) oidcLoginHandler(w http.ResponseWriter, r *http.Request) {
+	provider, ok := app.oidc[app.readStringParam(r, "provider")]
+	if !ok {
+		app.notFoundResponse(w, r)
+
+		return
+	}
+
+	// the state protects the callback against forged requests, the nonce
+	// binds the ID token to this request and the verifier is the PKCE secret
+	var values [3]string
+
+	for i := range values {
+		value, err := oidc.RandomString()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+
+			return
+		}
+
+		values[i] = value
+	}
+
+	stateToken, nonce, codeVerifier := values[0], values[1], values[2]
+
+	state := &data.OIDCState{
+		Provider:     provider.Name,
+		Nonce:        nonce,
+		CodeVerifier: codeVerifier,
+	}
+
+	err := app.models.Identities.InsertState(stateToken, state, oidcStateTTL)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+
+		return
+	}
+
+	// bind the state to this browser so nobody else's callback can be completed in it
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    oidcStateHash(stateToken),
+		MaxAge:   int(oidcStateTTL.Seconds()),
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+		HttpOnly: true,
+		Path:     "/v1/oidc/",
+	})
+
+	authURL, err := provider.AuthCodeURL(r.Context(), stateToken, nonce, codeVerifier)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+
+		return
+	}
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// GET method with /v1/oidc/:provider/callback endpoint where the provider
+// redirects the user back, the identity is linked to the user with the same
+// verified email address or a new activated user is created
+func (app *application) oidcCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	provider, ok := app.oidc[app.readStringParam(r, "provider")]
+	if !ok {
+		app.notFoundResponse(w, r)
+
+		return
+	}
+
+	qs := r.URL.Query()
+
+	// the user denied the authorization or the provider failed
+	if qs.Get("error") != "" {
+		app.badRequestResponse(w, r, fmt.Errorf("%s: %s", qs.Get("error"), qs.Get("error_description")))
+
+		return
+	}
+
+	// the login must have been started in the same browser, otherwise someone
+	// could log the user into their own account with their callback URL
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(oidcStateHash(qs.Get("state")))) != 1 {
+		app.badRequestResponse(w, r, errors.New("invalid or expired state"))
+
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    "",
+		MaxAge:   -1,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+		HttpOnly: true,
+		Path:     "/v1/oidc/",
+	})
+
+	state, err := app.models.Identities.ConsumeState(qs.Get("state"), provider.Name)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.badRequestResponse(w, r, errors.New("invalid or expired state"))
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	claims, err := provider.Exchange(r.Context(), qs.Get("code"), state.CodeVerifier, state.Nonce)
+	if err != nil {
+		switch {
+		case errors.Is(err, oidc.ErrInvalidIDToken):
+			app.badRequestResponse(w, r, err)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	user, err := app.models.Identities.GetUser(provider.Name, claims.Subject)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			user, ok = app.linkIdentity(w, r, provider.Name, claims)
+			if !ok {
+				return
+			}
+		default:
+			app.serverErrorResponse(w, r, err)
+
+			return
+		}
+	}
+
+	if user.Disabled {
+		app.disabledAccountResponse(w, r)
+
+		return
+	}
+
+	// the provider only replaces the password, the second factor is still required
+	mfaToken, err := app.newMFAChallenge(user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+
+		return
+	}
+
+	if mfaToken != nil {
+		if app.config.oidc.successURL != "" {
+			app.redirectToSuccessURL(w, r, url.Values{"mfa_token": {mfaToken.Plaintext}})
+
+			return
+		}
+
+		env := envelope{
+			"mfa_token": mfaToken,
+			"message":   "enter the code of your authenticator app or a recovery code to complete the login.",
+		}
+
+		err = app.writeJSON(w, http.StatusAccepted, env, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	accessToken, refreshToken, err := app.startSession(r, user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+
+		return
+	}
+
+	app.setTokenCookies(w, accessToken, refreshToken)
+
+	if app.config.oidc.successURL != "" {
+		app.redirectToSuccessURL(w, r, nil)
+
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"user": user, "csrf_token": app.csrfToken(*refreshToken.SessionID)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// link a new identity to the user with the same email address, the user is
+// created when there's none, only verified email addresses are trusted
+func (app *application) linkIdentity(w http.ResponseWriter, r *http.Request, provider string, claims *oidc.Claims) (*data.User, bool) {
+	if claims.Email == "" || !claims.EmailVerified {
+		app.unverifiedEmailResponse(w, r)
+
+		return nil, false
+	}
+
+	user, err := app.models.Users.GetByEmail(claims.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			// the providers can't be used to get around the invitations
+			if app.config.users.registration == "invite" {
+				app.invitationRequiredResponse(w, r)
+
+				return nil, false
+			}
+
+			user, err = app.createOIDCUser(claims)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+
+				return nil, false
+			}
+		default:
+			app.serverErrorResponse(w, r, err)
+
+			return nil, false
+		}
+	}
+
+	// the provider has verified the email address so there's no need
+	// for the activation email, but whoever registered the unverified
+	// address might not be its owner so their access is taken away
+	if !user.Activated {
+		err = app.claimUnactivatedUser(user)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrEditConflict):
+				app.editConflictResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+
+			return nil, false
+		}
+	}
+
+	identity := &data.Identity{
+		Provider: provider,
+		Subject:  claims.Subject,
+		UserID:   user.ID,
+		Email:    claims.Email,
+	}
+
+	err = app.models.Identities.Insert(identity)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+
+		return nil, false
+	}
+
+	return user, true
+}
+
+// activate the user with a random password and remove the sessions, API keys
+// and authenticator the registrant might have set up before the address was verified
+func (app *application) claimUnactivatedUser(user *data.User) error {
+	password, err := oidc.RandomString()
+	if err != nil {
+		return err
+	}
+
+	err = user.Password.Set(password)
+	if err != nil {
+		return err
+	}
+
+	user.Activated = true
+
+	err = app.models.Users.Update(user)
+	if err != nil {
+		return err
+	}
+
+	err = app.models.Sessions.DeleteAllForUser(user.ID)
+	if err != nil {
+		return err
+	}
+
+	err = app.models.APIKeys.DeleteAllForUser(user.ID)
+	if err != nil {
+		return err
+	}
+
+	return app.models.TwoFactor.Delete(user.ID)
+}
+
+// create an activated user with the default role, the random password is
+// never revealed so it has to be reset to log in without the provider
+func (app *application) createOIDCUser(claims *oidc.Claims) (*data.User, error) {
+	name := strings.TrimSpace(claims.Name)
+	if name == "" || len(name) > 500 {
+		name = strings.SplitN(claims.Email, "@", 2)[0]
+	}
+
+	user := &data.User{
+		Name:      name,
+		Email:     claims.Email,
+		Activated: true,
+	}
+
+	password, err := oidc.RandomString()
+	if err != nil {
+		return nil, err
+	}
+
+	err = user.Password.Set(password)
+	if err != nil {
+		return nil, err
+	}
+
+	v := validator.New()
+
+	if data.ValidateUser(v, user); !v.Valid() {
+		return nil, fmt.Errorf("invalid user from oidc claims: %v", v.Errors)
+	}
+
+	err = app.models.Users.Insert(user)
+	if err != nil {
+		return nil, err
+	}
+
+	err = app.models.Roles.AddForUser(user.ID, app.config.users.defaultRole)
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// redirect the user's browser back to the frontend, the values are put in the
+// fragment which is neither sent to the server nor leaked in the Referer header
+func (app *application) redirectToSuccessURL(w http.ResponseWriter, r *http.Request, fragment url.Values) {
+	target := app.config.oidc.successURL
+
+	if len(fragment) > 0 {
+		separator := "#"
+		if strings.Contains(target, "#") {
+			separator = "&"
+		}
+
+		target += separator + fragment.Encode()
+	}
+
+	http.Redirect(w, r, target, http.StatusSeeOther)
+}
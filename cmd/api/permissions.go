@@ -0,0 +1,111 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"api.cinevie.jpranata.tech/internal/data"
+)
+
+// short-lived in-process cache of the users' permissions keyed by the user id
+// so the protected endpoints don't have to join three tables on every request
+type permissionCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[int64]permissionCacheEntry
+}
+
+type permissionCacheEntry struct {
+	permissions data.Permissions
+	expiry      time.Time
+}
+
+// a ttl of zero (or less) disables the cache
+func newPermissionCache(ttl time.Duration) *permissionCache {
+	cache := &permissionCache{
+		ttl:     ttl,
+		entries: make(map[int64]permissionCacheEntry),
+	}
+
+	if ttl <= 0 {
+		return cache
+	}
+
+	// remove the expired entries once every minute
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+
+			cache.mu.Lock()
+
+			for id, entry := range cache.entries {
+				if time.Now().After(entry.expiry) {
+					delete(cache.entries, id)
+				}
+			}
+
+			cache.mu.Unlock()
+		}
+	}()
+
+	return cache
+}
+
+func (c *permissionCache) get(userID int64) (data.Permissions, bool) {
+	if c.ttl <= 0 {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, found := c.entries[userID]
+	if !found || time.Now().After(entry.expiry) {
+		return nil, false
+	}
+
+	return entry.permissions, true
+}
+
+func (c *permissionCache) set(userID int64, permissions data.Permissions) {
+	if c.ttl <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[userID] = permissionCacheEntry{
+		permissions: permissions,
+		expiry:      time.Now().Add(c.ttl),
+	}
+}
+
+// drop the cached permissions of the user after their grants have changed
+func (c *permissionCache) invalidate(userID int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, userID)
+}
+
+// returns the permissions of the user from the cache, loading them from
+// the database if they aren't cached (or have expired)
+func (app *application) userPermissions(userID int64) (data.Permissions, error) {
+	if permissions, found := app.permissions.get(userID); found {
+		return permissions, nil
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(permissions) == 0 {
+		permissions = data.Permissions{}
+	}
+
+	app.permissions.set(userID, permissions)
+
+	return permissions, nil
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the requests per second a bucket refills with and how many it holds at most
+type rateLimitPolicy struct {
+	rps   float64
+	burst int
+}
+
+// a stricter or looser policy of the routes matching the method and the
+// pattern, the routes with the same name share their buckets
+type routeRateLimit struct {
+	name    string
+	method  string
+	pattern string
+	policy  rateLimitPolicy
+}
+
+// the routes whose policy replaces the default one, the first match wins
+func rateLimitRoutes(cfg config) []routeRateLimit {
+	auth := cfg.limiter.auth
+	accounts := cfg.limiter.accounts
+	reads := cfg.limiter.reads
+
+	return []routeRateLimit{
+		{"auth", http.MethodPost, "/v1/tokens/authentication", auth},
+		{"auth", http.MethodPost, "/v1/tokens/bearer", auth},
+		{"auth", http.MethodPost, "/v1/tokens/magic-link/exchange", auth},
+		{"accounts", http.MethodPost, "/v1/users", accounts},
+		{"accounts", http.MethodPost, "/v1/tokens/activation", accounts},
+		{"accounts", http.MethodPost, "/v1/tokens/password-reset", accounts},
+		{"accounts", http.MethodPost, "/v1/tokens/magic-link", accounts},
+		{"comments", http.MethodPost, "/v1/movies/:id/comments", cfg.limiter.comments},
+		{"reads", http.MethodGet, "/v1/movies", reads},
+		{"reads", http.MethodGet, "/v1/movies/:id", reads},
+		{"reads", http.MethodGet, "/v1/movies/:id/comments", reads},
+		{"reads", http.MethodGet, "/v1/movies/:id/tags", reads},
+	}
+}
+
+// match the path against a pattern whose :name segments match any segment
+func matchRoutePattern(pattern, path string) bool {
+	patternSegments := strings.Split(strings.Trim(pattern, "/"), "/")
+	pathSegments := strings.Split(strings.Trim(path, "/"), "/")
+
+	if len(patternSegments) != len(pathSegments) {
+		return false
+	}
+
+	for i, segment := range patternSegments {
+		if strings.HasPrefix(segment, ":") {
+			if pathSegments[i] == "" {
+				return false
+			}
+
+			continue
+		}
+
+		if segment != pathSegments[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+type rateLimitBucket struct {
+	tokens   float64
+	lastSeen time.Time
+	policy   rateLimitPolicy
+}
+
+// the state of a bucket once a request has been counted
+type rateLimitResult struct {
+	allowed    bool
+	limit      int
+	remaining  int
+	reset      time.Duration
+	retryAfter time.Duration
+}
+
+// token buckets of the anonymous clients by their IP address and of the
+// authenticated users by their id, separately for every route policy
+type rateLimiter struct {
+	mu        sync.Mutex
+	anonymous rateLimitPolicy
+	user      rateLimitPolicy
+	routes    []routeRateLimit
+	buckets   map[string]*rateLimitBucket
+}
+
+func newRateLimiter(anonymous, user rateLimitPolicy, routes []routeRateLimit) *rateLimiter {
+	limiter := &rateLimiter{
+		anonymous: anonymous,
+		user:      user,
+		routes:    routes,
+		buckets:   make(map[string]*rateLimitBucket),
+	}
+
+	// remove the buckets which would have been refilled by now once every minute,
+	// a new bucket starts out full anyway
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+
+			limiter.mu.Lock()
+
+			for key, bucket := range limiter.buckets {
+				elapsed := time.Since(bucket.lastSeen).Seconds()
+				if bucket.tokens+elapsed*bucket.policy.rps >= float64(bucket.policy.burst) {
+					delete(limiter.buckets, key)
+				}
+			}
+
+			limiter.mu.Unlock()
+		}
+	}()
+
+	return limiter
+}
+
+// the name and the policy of the bucket the request is counted against
+func (l *rateLimiter) policy(r *http.Request, anonymous bool) (string, rateLimitPolicy) {
+	for _, route := range l.routes {
+		if route.method == r.Method && matchRoutePattern(route.pattern, r.URL.Path) {
+			return route.name, route.policy
+		}
+	}
+
+	if anonymous {
+		return "anonymous", l.anonymous
+	}
+
+	return "user", l.user
+}
+
+// take a token out of the bucket when there's one left
+func (l *rateLimiter) allow(key string, policy rateLimitPolicy) rateLimitResult {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+
+	bucket, found := l.buckets[key]
+	if !found {
+		bucket = &rateLimitBucket{tokens: float64(policy.burst), lastSeen: now, policy: policy}
+		l.buckets[key] = bucket
+	}
+
+	// refill the tokens for the time passed since the last request
+	bucket.tokens = math.Min(float64(policy.burst), bucket.tokens+now.Sub(bucket.lastSeen).Seconds()*policy.rps)
+	bucket.lastSeen = now
+
+	result := rateLimitResult{limit: policy.burst}
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		result.allowed = true
+	} else if policy.rps > 0 {
+		result.retryAfter = secondsDuration((1 - bucket.tokens) / policy.rps)
+	}
+
+	result.remaining = int(bucket.tokens)
+
+	if policy.rps > 0 {
+		result.reset = secondsDuration((float64(policy.burst) - bucket.tokens) / policy.rps)
+	}
+
+	return result
+}
+
+func secondsDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// count the request against the bucket of the policy, the RateLimit headers
+// are sent either way and the 429 response once the bucket is empty
+func (app *application) allowRequest(w http.ResponseWriter, r *http.Request, key string, policy rateLimitPolicy) bool {
+	result := app.limiter.allow(key, policy)
+
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(result.limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.remaining))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(int(math.Ceil(result.reset.Seconds()))))
+
+	if !result.allowed {
+		app.rateLimitExceededResponse(w, r, result.retryAfter)
+
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"sync"
+)
+
+// in-memory copy of the sessions revoked within the lifetime of an access token,
+// the signed tokens are checked against it instead of the database
+type revocationList struct {
+	mu       sync.RWMutex
+	sessions map[int64]bool
+}
+
+func newRevocationList() *revocationList {
+	return &revocationList{
+		sessions: make(map[int64]bool),
+	}
+}
+
+// revoke the session right away in this instance, the other instances
+// pick it up on their next synchronization
+func (l *revocationList) add(sessionID int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sessions[sessionID] = true
+}
+
+// replace the whole list with the one loaded from the database
+func (l *revocationList) replace(sessionIDs []int64) {
+	sessions := make(map[int64]bool, len(sessionIDs))
+
+	for _, id := range sessionIDs {
+		sessions[id] = true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sessions = sessions
+}
+
+func (l *revocationList) has(sessionID int64) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.sessions[sessionID]
+}
@@ -40,18 +40,86 @@ func (app *application) routes() http.Handler {
 	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.requirePermission("movies:write", app.updateMovieHandler))
 	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.requirePermission("movies:write", app.deleteMovieHandler))
 
+	// tags
+	router.HandlerFunc(http.MethodGet, "/v1/tags", app.requirePermission("movies:read", app.listTagsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/tags/:slug/movies", app.requirePermission("movies:read", app.listTagMoviesHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/tags/:slug", app.requirePermission("tags:moderate", app.deleteTagHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/tags", app.requirePermission("movies:read", app.listMovieTagsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/tags", app.requirePermission("movies:read", app.createMovieTagHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/movies/:id/tags/:slug/vote", app.requirePermission("movies:read", app.voteMovieTagHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id/tags/:slug", app.requirePermission("tags:moderate", app.deleteMovieTagHandler))
+
+	// comments
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/comments", app.requirePermission("movies:read", app.listMovieCommentsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/comments", app.requirePermission("movies:read", app.createMovieCommentHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/comments/:id", app.requirePermission("movies:read", app.showCommentHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/comments/:id", app.requirePermission("movies:read", app.updateCommentHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/comments/:id", app.requirePermission("movies:read", app.deleteCommentHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/comments/:id/reports", app.requirePermission("movies:read", app.createCommentReportHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/moderation/comments", app.requirePermission("comments:moderate", app.listReportedCommentsHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/moderation/comments/:id/resolved", app.requirePermission("comments:moderate", app.resolveCommentReportsHandler))
+
 	//users
 	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
 	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
 	router.HandlerFunc(http.MethodPut, "/v1/users/password", app.updateUserPasswordHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/users/me", app.requireSession(app.showCurrentUserHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/users/me", app.requireSession(app.updateCurrentUserHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me", app.requireSession(app.deleteCurrentUserHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/export", app.requireSession(app.exportCurrentUserHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/users/me/password", app.requireSession(app.updateCurrentUserPasswordHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/sessions", app.requireSession(app.listSessionsHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/sessions", app.requireSession(app.deleteOtherSessionsHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/sessions/:id", app.requireSession(app.deleteSessionHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/mfa/totp", app.requireSession(app.createTOTPHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/mfa/totp", app.requireSession(app.deleteTOTPHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/mfa/totp/confirm", app.requireSession(app.confirmTOTPHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/mfa/recovery-codes", app.requireSession(app.createRecoveryCodesHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/api-keys", app.requireSession(app.requireActivatedUser(app.listAPIKeysHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/api-keys", app.requireSession(app.requireActivatedUser(app.createAPIKeyHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/api-keys/:id", app.requireSession(app.requireActivatedUser(app.deleteAPIKeyHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/email", app.requireSession(app.requireActivatedUser(app.createEmailChangeHandler)))
+	router.HandlerFunc(http.MethodPut, "/v1/users/email", app.confirmEmailChangeHandler)
+
+	// administration
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users", app.requirePermission("users:admin", app.listUsersHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users/:id", app.requirePermission("users:admin", app.showUserHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/admin/users/:id", app.requirePermission("users:admin", app.updateUserHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id", app.requirePermission("users:admin", app.deleteUserHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/lockout", app.requirePermission("users:admin", app.unlockUserHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/users/:id/password-reset", app.requirePermission("users:admin", app.forceUserPasswordResetHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/users/:id/permissions", app.requirePermission("users:admin", app.grantUserPermissionsHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/permissions/:code", app.requirePermission("users:admin", app.revokeUserPermissionHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/users/:id/roles", app.requirePermission("users:admin", app.assignUserRolesHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/roles/:role", app.requirePermission("users:admin", app.removeUserRoleHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/permissions", app.requirePermission("users:admin", app.listPermissionsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/roles", app.requirePermission("users:admin", app.listRolesHandler))
+
+	// invitations
+	router.HandlerFunc(http.MethodGet, "/v1/invitations", app.requirePermission("invites:create", app.listInvitationsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/invitations", app.requirePermission("invites:create", app.createInvitationHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/invitations/:id", app.requirePermission("invites:create", app.deleteInvitationHandler))
 
 	// tokens
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/activation", app.createActivationTokenHandler)
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/bearer", app.createBearerTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/magic-link", app.createMagicLinkTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/magic-link/exchange", app.exchangeMagicLinkTokenHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/tokens/csrf", app.requireAuthenticatedUser(app.showCSRFTokenHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/refresh", app.refreshAuthenticationTokenHandler)
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/logout", app.requireAuthenticatedUser(app.deleteAuthenticationTokenHandler))
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/password-reset", app.createPasswordResetTokenHandler)
 
-	// add the enableCORS() middleware
-	// put it before rateLimit to prevent request exceeded of 429 too many request response
-	return app.metrics(app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(router)))))
+	// single sign-on with the OpenID Connect providers
+	router.HandlerFunc(http.MethodGet, "/v1/oidc/:provider/login", app.oidcLoginHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/oidc/:provider/callback", app.oidcCallbackHandler)
+
+	// metrics sees every response and recoverPanic turns a panic in any of the
+	// middleware after it into a JSON response, the security headers are set on
+	// every response and the client's IP address is resolved before anything
+	// uses it, enableCORS answers the preflight requests before they could be
+	// rate limited, authenticate runs before rateLimit so the users get their own
+	// buckets and the CSRF check comes last when the session is known
+	return app.metrics(app.recoverPanic(app.securityHeaders(app.clientIP(app.enableCORS(app.authenticate(app.rateLimit(app.csrfProtect(router))))))))
 }
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"api.cinevie.jpranata.tech/internal/data"
+)
+
+// GET method with /v1/users/me/sessions endpoint to list the devices
+// the current user is logged in on
+func (app *application) listSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	// there is no current session when an API key is being used
+	var currentSessionID int64
+	if session := app.contextGetSession(r); session != nil {
+		currentSessionID = session.ID
+	}
+
+	sessions, err := app.models.Sessions.GetAllForUser(user.ID, currentSessionID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"sessions": sessions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// DELETE method with /v1/users/me/sessions/:id endpoint to log out a specific device
+func (app *application) deleteSessionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	err = app.models.Sessions.Delete(id, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	app.revocations.add(id)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "session successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// DELETE method with /v1/users/me/sessions endpoint to log out everywhere else
+func (app *application) deleteOtherSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	var currentSessionID int64
+	if session := app.contextGetSession(r); session != nil {
+		currentSessionID = session.ID
+	}
+
+	revoked, err := app.models.Sessions.DeleteAllForUserExcept(user.ID, currentSessionID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"revoked": revoked}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
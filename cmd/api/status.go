@@ -21,25 +21,13 @@ func (app *application) statusHandler(w http.ResponseWriter, r *http.Request) {
 
 // granting permissions for browser based client to access movies resource
 func (app *application) permissionsHandler(w http.ResponseWriter, r *http.Request) {
-  // retrieve the user from request context
-  user := app.contextGetUser(r)
+	// get the slice codes of permissions for the user from the request context,
+	// anonymous user has an empty slice
+	permissions := app.contextGetPermissions(r)
 
-  // get the slice codes of permissions for the user
-  permissions, err := app.models.Permissions.GetAllForUser(user.ID)
-  if err != nil {
-      app.serverErrorResponse(w, r, err)
-
-      return
-  }
-
-  if len(permissions) == 0 {
-    permissions = []string{}
-  }
-
-  // if there are no granted permission exist then response with empty string
-  err = app.writeJSON(w, http.StatusOK, envelope{"permissions": permissions}, nil)
-  if err != nil {
-      app.serverErrorResponse(w, r, err)
-  }
+	// if there are no granted permission exist then response with empty string
+	err := app.writeJSON(w, http.StatusOK, envelope{"permissions": permissions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
 }
-
@@ -3,12 +3,17 @@ package main
 import (
 	"errors"
 	"net/http"
+	"strconv"
 	"time"
 
 	"api.cinevie.jpranata.tech/internal/data"
+	"api.cinevie.jpranata.tech/internal/jwt"
 	"api.cinevie.jpranata.tech/internal/validator"
 )
 
+// POST method with /v1/tokens/activation endpoint to send a new activation token,
+// the response is the same whether or not the email address is registered or
+// activated, an activated user is told so by email instead
 func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *http.Request) {
 	// parse and validate user's email address
 	var input struct {
@@ -18,6 +23,8 @@ func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *h
 	err := app.readJSON(w, r, &input)
 	if err != nil {
 		app.badRequestResponse(w, r, err)
+
+		return
 	}
 
 	v := validator.New()
@@ -28,38 +35,42 @@ func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *h
 		return
 	}
 
-	// retrieve corresponding record for the email address
-	user, err := app.models.Users.GetByEmail(input.Email)
-	if err != nil {
-		switch {
-		case errors.Is(err, data.ErrRecordNotFound):
-			v.AddError("email", "no matching email address found.")
-			app.failedValidationResponse(w, r, v.Errors)
-		default:
-			app.serverErrorResponse(w, r, err)
+	// the user is looked up in the background so neither the response
+	// nor its timing reveals the state of the email address
+	app.background(func() {
+		if !app.allowEmail(data.ScopeActivation, input.Email) {
+			return
 		}
 
-		return
-	}
+		// retrieve corresponding record for the email address
+		user, err := app.models.Users.GetByEmail(input.Email)
+		if err != nil {
+			if !errors.Is(err, data.ErrRecordNotFound) {
+				app.logger.PrintError(err, nil)
+			}
 
-	// return an error if the user has already been activated
-	if user.Activated {
-		v.AddError("email", "user has already been activated.")
-		app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
 
-		return
-	}
+		if user.Activated {
+			err = app.mailer.Send(user.Email, "user_already_activated.tmpl", map[string]interface{}{
+				"userName": user.Name,
+			})
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
 
-	// otherwise create an new activation token
-	token, err := app.models.Tokens.New(user.ID, 3*time.Hour, data.ScopeActivation)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
+			return
+		}
 
-		return
-	}
+		// otherwise create an new activation token
+		token, err := app.models.Tokens.New(user.ID, 3*time.Hour, data.ScopeActivation)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+
+			return
+		}
 
-	// email user with their additional activation token
-	app.background(func() {
 		data := map[string]interface{}{
 			"activationToken": token.Plaintext,
 			"userName":        user.Name,
@@ -73,7 +84,7 @@ func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *h
 	})
 
 	// send 202 Accepted response and configuration message to the client
-	env := envelope{"message": "an email will be sent to you containing activation instructions."}
+	env := envelope{"message": "if the email address belongs to an account which isn't activated yet, an email will be sent to it containing activation instructions."}
 
 	err = app.writeJSON(w, http.StatusAccepted, env, nil)
 	if err != nil {
@@ -81,154 +92,440 @@ func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *h
 	}
 }
 
-func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
-	// parse the email and password from the request body
+// check the email and password from the request body and start a new session
+// with its access and refresh tokens, users with two-factor authentication
+// send their code along with the mfa_token in a second request, the response
+// has already been sent to the client when it returns false
+func (app *application) login(w http.ResponseWriter, r *http.Request) (*data.Token, *data.Token, bool) {
+	// parse the email and password (or the second factor) from the request body
 	var input struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
+		Email        string `json:"email"`
+		Password     string `json:"password"`
+		MFAToken     string `json:"mfa_token"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
 	}
 
 	err := app.readJSON(w, r, &input) // parse and assign them to input struct
 	if err != nil {
 		app.badRequestResponse(w, r, err)
 
-		return
+		return nil, nil, false
+	}
+
+	var user *data.User
+	var ok bool
+
+	if input.MFAToken != "" {
+		user, ok = app.verifySecondFactor(w, r, input.MFAToken, input.Code, input.RecoveryCode)
+	} else {
+		user, ok = app.verifyPassword(w, r, input.Email, input.Password)
+	}
+
+	if !ok {
+		return nil, nil, false
+	}
+
+	// the password is only the first step for the users with two-factor authentication
+	return app.completeLogin(w, r, user, input.MFAToken == "")
+}
+
+// start the session of the user who passed the first factor (or both), the
+// users with two-factor authentication are challenged for the second one instead
+func (app *application) completeLogin(w http.ResponseWriter, r *http.Request, user *data.User, challenge bool) (*data.Token, *data.Token, bool) {
+	// the account has been disabled by an administrator
+	if user.Disabled {
+		app.disabledAccountResponse(w, r)
+
+		return nil, nil, false
+	}
+
+	if challenge {
+		token, err := app.newMFAChallenge(user)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+
+			return nil, nil, false
+		}
+
+		if token != nil {
+			env := envelope{
+				"mfa_token": token,
+				"message":   "enter the code of your authenticator app or a recovery code to complete the login.",
+			}
+
+			err = app.writeJSON(w, http.StatusAccepted, env, nil)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+			}
+
+			return nil, nil, false
+		}
+	}
+
+	// the failed attempts are only forgotten once the login is complete, so
+	// the password can't be used to reset the count of the failed codes
+	err := app.clearLoginFailures(user.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+
+		return nil, nil, false
+	}
+
+	accessToken, refreshToken, err := app.startSession(r, user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+
+		return nil, nil, false
+	}
+
+	return accessToken, refreshToken, true
+}
+
+// returns a new mfa-pending token when the user has enabled two-factor
+// authentication and nil otherwise
+func (app *application) newMFAChallenge(user *data.User) (*data.Token, error) {
+	totp, err := app.models.TwoFactor.GetTOTP(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return nil, nil
+		default:
+			return nil, err
+		}
+	}
+
+	if !totp.IsConfirmed() {
+		return nil, nil
 	}
 
+	return app.models.Tokens.New(user.ID, 5*time.Minute, data.ScopeMFAPending)
+}
+
+// start a new session for the fully authenticated user, every login starts
+// a new session so the user stays logged in on their other devices
+func (app *application) startSession(r *http.Request, user *data.User) (*data.Token, *data.Token, error) {
+	// logging in during the deletion grace period cancels the deletion
+	_, err := app.models.Users.CancelDeletion(user.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	session := &data.Session{
+		UserID:    user.ID,
+		UserAgent: r.UserAgent(),
+		IP:        app.contextGetClientIP(r),
+	}
+
+	err = app.models.Sessions.Insert(session)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return app.newSessionTokens(user, session.ID)
+}
+
+// first step of the login, check the email and password
+func (app *application) verifyPassword(w http.ResponseWriter, r *http.Request, email, password string) (*data.User, bool) {
 	// validate the email and password
 	v := validator.New()
-	data.ValidateEmail(v, input.Email)
-	data.ValidatePasswordPlaintext(v, input.Password)
+	data.ValidateEmail(v, email)
+	data.ValidatePasswordPlaintext(v, password)
 
 	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 
-		return
+		return nil, false
+	}
+
+	// a locked out email or IP address is rejected before checking the password
+	if !app.checkLoginLockout(w, r, email) {
+		return nil, false
 	}
 
 	// if doesn't match return invalidCrendentialsResponse with 401 code
-	user, err := app.models.Users.GetByEmail(input.Email)
+	user, err := app.models.Users.GetByEmail(email)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
+			err = app.recordLoginFailure(r, email, nil)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+
+				return nil, false
+			}
+
 			app.invalidEmailOrPasswordResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 
-		return
+		return nil, false
 	}
 
 	// check if password matches
-	match, err := user.Password.Matches(input.Password)
+	match, err := user.Password.Matches(password)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 
-		return
+		return nil, false
 	}
 
 	// if the password didn't match then call invalidCredentialsResponse again
 	if !match {
+		err = app.recordLoginFailure(r, email, user)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+
+			return nil, false
+		}
+
 		app.invalidEmailOrPasswordResponse(w, r)
 
-		return
+		return nil, false
+	}
+
+	// upgrade the hash to the current algorithm and cost while the plain text
+	// password is known, a failed upgrade is only logged and retried next time
+	if user.Password.NeedsRehash() {
+		err = app.rehashPassword(user, password)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"user_id": strconv.FormatInt(user.ID, 10)})
+		}
+	}
+
+	return user, true
+}
+
+// hash the password again with the current parameters, an edit conflict means
+// the user has been updated in the meantime so the hash is left as it is
+func (app *application) rehashPassword(user *data.User, password string) error {
+	err := user.Password.Set(password)
+	if err != nil {
+		return err
 	}
 
-    // delete the previous token if it exists
-	err = app.models.Tokens.DeleteAllForUser(data.ScopeAuthentication, user.ID)
+	err = app.models.Users.Update(user)
+	if err != nil && !errors.Is(err, data.ErrEditConflict) {
+		return err
+	}
+
+	return nil
+}
+
+// second step of the login, check the code of the authenticator app
+// (or a recovery code) of the user the mfa-pending token belongs to
+func (app *application) verifySecondFactor(w http.ResponseWriter, r *http.Request, mfaToken, code, recoveryCode string) (*data.User, bool) {
+	v := validator.New()
+
+	data.ValidateTokenPlaintext(v, mfaToken)
+	v.Check(code != "" || recoveryCode != "", "code", "must be provided.")
+
+	if code != "" {
+		data.ValidateTOTPCode(v, code)
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+
+		return nil, false
+	}
+
+	user, err := app.models.Users.GetForToken(data.ScopeMFAPending, mfaToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+
+		return nil, false
+	}
 
-	// generate a new token with the 24 hours expiry and the scope 'authentication'
-	token, err := app.models.Tokens.New(user.ID, 24*time.Hour, data.ScopeAuthentication)
+	// the failed codes count towards the lockout of the account as well
+	if !app.checkLoginLockout(w, r, user.Email) {
+		return nil, false
+	}
+
+	valid, err := app.checkSecondFactor(user.ID, code, recoveryCode)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
-		return
+
+		return nil, false
 	}
 
-	http.SetCookie(w, &http.Cookie{
-	  Name: "session_token",
-	  Value: token.Plaintext,
-	  Expires: token.Expiry,
-      Secure:   true,
-      SameSite: http.SameSiteNoneMode,
-      HttpOnly: true,
-      Path: "/",
-	})
+	if !valid {
+		err = app.recordLoginFailure(r, user.Email, user)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+
+			return nil, false
+		}
+
+		app.invalidSecondFactorResponse(w, r)
+
+		return nil, false
+	}
 
-	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication": "Success"}, nil)
+	// the mfa-pending token can only be used once
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeMFAPending, user.ID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+
+		return nil, false
 	}
+
+	return user, true
 }
 
-// logout: delete an existing token and send an empty session_token
-func (app *application) deleteAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
-    token, err := r.Cookie("session_token")
-    if err != nil {
-      if err == http.ErrNoCookie {
-		app.invalidCredentialsResponse(w, r)
+// generate the short-lived access token with the scope 'authentication'
+// along with the refresh token for the session
+func (app *application) newSessionTokens(user *data.User, sessionID int64) (*data.Token, *data.Token, error) {
+	var accessToken *data.Token
+	var err error
 
-        return
-      }
+	if app.jwtKeys != nil {
+		accessToken, err = app.newSignedToken(user, sessionID)
+	} else {
+		accessToken, err = app.models.Tokens.NewForSession(user.ID, sessionID, app.config.auth.accessTokenTTL, data.ScopeAuthentication)
+	}
 
-      app.badRequestResponse(w, r, err)
+	if err != nil {
+		return nil, nil, err
+	}
 
-      return
-    }
+	refreshToken, err := app.models.Tokens.NewForSession(user.ID, sessionID, app.config.auth.refreshTokenTTL, data.ScopeRefresh)
+	if err != nil {
+		return nil, nil, err
+	}
 
-    tokenPlaintext := token.Value
+	return accessToken, refreshToken, nil
+}
+
+// sign an access token carrying the user's id, activation state and permissions,
+// it isn't stored so it can only be revoked through its session
+func (app *application) newSignedToken(user *data.User, sessionID int64) (*data.Token, error) {
+	permissions, err := app.userPermissions(user.ID)
+	if err != nil {
+		return nil, err
+	}
 
-    err = app.models.Tokens.DeleteToken(data.ScopeAuthentication, tokenPlaintext)
+	now := time.Now()
 
+	token := &data.Token{
+		UserID:    user.ID,
+		Expiry:    now.Add(app.config.auth.accessTokenTTL),
+		Scope:     data.ScopeAuthentication,
+		SessionID: &sessionID,
+	}
+
+	token.Plaintext, err = app.jwtKeys.Sign(jwt.Claims{
+		Subject:     user.ID,
+		SessionID:   sessionID,
+		Activated:   user.Activated,
+		Permissions: permissions,
+		IssuedAt:    now.Unix(),
+		ExpiresAt:   token.Expiry.Unix(),
+	})
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// login for browser based clients, the tokens are sent in the session_token
+// and refresh_token cookies
+func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	accessToken, refreshToken, ok := app.login(w, r)
+	if !ok {
 		return
 	}
 
-	http.SetCookie(w, &http.Cookie{
-	  Name: "session_token",
-	  Value: "",
-	  Expires: time.Now(),
-      Secure:   true,
-      SameSite: http.SameSiteNoneMode,
-      HttpOnly: true,
-      Path: "/",
-	})
+	app.setTokenCookies(w, accessToken, refreshToken)
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"logout": "Success"}, nil)
+	env := envelope{"authentication": "Success", "csrf_token": app.csrfToken(*refreshToken.SessionID)}
+
+	err := app.writeJSON(w, http.StatusCreated, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
-// generate a password reset and send it to the user's email address
-func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r *http.Request) {
-	// parse and validate the user's email address
-	var input struct {
-		Email string `json:"email"`
+// login for non-browser clients like CLI scripts and server-to-server integrations,
+// the tokens are sent in the response body and the access token must be passed
+// back in the "Authorization: Bearer <token>" header
+func (app *application) createBearerTokenHandler(w http.ResponseWriter, r *http.Request) {
+	accessToken, refreshToken, ok := app.login(w, r)
+	if !ok {
+		return
 	}
 
-	err := app.readJSON(w, r, &input)
+	err := app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": accessToken, "refresh_token": refreshToken}, nil)
 	if err != nil {
-		app.badRequestResponse(w, r, err)
+		app.serverErrorResponse(w, r, err)
+	}
+}
 
-		return
+// exchange the refresh token for a new pair of access and refresh tokens,
+// the refresh token can only be used once and replaying it revokes the session
+func (app *application) refreshAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	// browser based clients send the refresh token in its cookie
+	cookie, err := r.Cookie("refresh_token")
+	fromCookie := err == nil
+
+	if fromCookie {
+		input.RefreshToken = cookie.Value
+	} else {
+		err = app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+
+			return
+		}
 	}
 
 	v := validator.New()
 
-	if data.ValidateEmail(v, input.Email); !v.Valid() {
+	if data.ValidateTokenPlaintext(v, input.RefreshToken); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 
 		return
 	}
 
-	// retrieve the corresponding user record for the email address
-	user, err := app.models.Users.GetByEmail(input.Email)
+	token, err := app.models.Tokens.Rotate(input.RefreshToken, app.config.auth.refreshReuseGrace)
 	if err != nil {
 		switch {
+		case errors.Is(err, data.ErrTokenRecentlyRotated):
+			// another tab refreshed with the same token a moment ago, the browser
+			// already has its tokens so nothing is issued or revoked, the cookies
+			// are left alone since they hold the new tokens
+			app.invalidAuthenticationTokenResponse(w, r)
+		case errors.Is(err, data.ErrTokenReused):
+			// the token has been stolen either from the user or from whoever
+			// used it first, revoke the whole session to be safe
+			if token.SessionID != nil {
+				err = app.models.Sessions.Delete(*token.SessionID, token.UserID)
+				if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+					app.serverErrorResponse(w, r, err)
+
+					return
+				}
+			}
+
+			app.logger.PrintInfo("refresh token reuse detected", map[string]string{
+				"user_id": strconv.FormatInt(token.UserID, 10),
+			})
+
+			app.clearTokenCookies(w)
+			app.invalidAuthenticationTokenResponse(w, r)
 		case errors.Is(err, data.ErrRecordNotFound):
-			v.AddError("email", "no matching email address found.")
-			app.failedValidationResponse(w, r, v.Errors)
+			app.invalidAuthenticationTokenResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
@@ -236,27 +533,160 @@ func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r
 		return
 	}
 
-	// return an error if the user is not activated
-	if !user.Activated {
-		v.AddError("email", "user account must be activated")
-		app.failedValidationResponse(w, r, v.Errors)
+	// every refresh token belongs to a session
+	if token.SessionID == nil {
+		app.invalidAuthenticationTokenResponse(w, r)
+
+		return
+	}
+
+	// the previous access tokens of the session stop working
+	err = app.models.Tokens.DeleteAllForSession(data.ScopeAuthentication, *token.SessionID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
 
 		return
 	}
 
-    // delete the previous token if it exists
-	err = app.models.Tokens.DeleteAllForUser(data.ScopePasswordReset, user.ID)
+	// the signed access token carries the current state of the user
+	user, err := app.models.Users.Get(token.UserID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 
-	// otherwise, create a new password reset token with a 45-minute expiry time
-	token, err := app.models.Tokens.New(user.ID, 45*time.Minute, data.ScopePasswordReset)
+		return
+	}
+
+	err = app.models.Sessions.Touch(*token.SessionID, app.contextGetClientIP(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+
+		return
+	}
+
+	accessToken, refreshToken, err := app.newSessionTokens(user, *token.SessionID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 
 		return
 	}
 
-	// email the user with their password reset token
+	if fromCookie {
+		app.setTokenCookies(w, accessToken, refreshToken)
+
+		env := envelope{"authentication": "Success", "csrf_token": app.csrfToken(*refreshToken.SessionID)}
+
+		err = app.writeJSON(w, http.StatusCreated, env, nil)
+	} else {
+		err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": accessToken, "refresh_token": refreshToken}, nil)
+	}
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// logout: delete the current session along with its token and send an empty session_token
+func (app *application) deleteAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	// API keys don't have a session to log out of, they are revoked instead
+	session := app.contextGetSession(r)
+	if session == nil {
+		app.invalidCredentialsResponse(w, r)
+
+		return
+	}
+
+	err := app.models.Sessions.Delete(session.ID, session.UserID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+
+		return
+	}
+
+	// reject the signed token of the session right away
+	app.revocations.add(session.ID)
+
+	app.clearTokenCookies(w)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"logout": "Success"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// generate a password reset and send it to the user's email address
+func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r *http.Request) {
+	// parse and validate the user's email address
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+
+		return
+	}
+
+	// the user is looked up in the background so neither the response
+	// nor its timing reveals the state of the email address
 	app.background(func() {
+		if !app.allowEmail(data.ScopePasswordReset, input.Email) {
+			return
+		}
+
+		// retrieve the corresponding user record for the email address
+		user, err := app.models.Users.GetByEmail(input.Email)
+		if err != nil {
+			if !errors.Is(err, data.ErrRecordNotFound) {
+				app.logger.PrintError(err, nil)
+			}
+
+			return
+		}
+
+		// the password of a user who isn't activated can't be reset,
+		// they're told to activate their account first
+		if !user.Activated {
+			err = app.mailer.Send(user.Email, "user_reset_not_activated.tmpl", map[string]interface{}{
+				"userName": user.Name,
+			})
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+
+			return
+		}
+
+		// delete the previous token if it exists
+		err = app.models.Tokens.DeleteAllForUser(data.ScopePasswordReset, user.ID)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+
+			return
+		}
+
+		// otherwise, create a new password reset token with a 45-minute expiry time
+		token, err := app.models.Tokens.New(user.ID, 45*time.Minute, data.ScopePasswordReset)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+
+			return
+		}
+
+		// email the user with their password reset token
 		data := map[string]interface{}{
 			"passwordResetToken": token.Plaintext,
 			"userName":           user.Name,
@@ -269,7 +699,7 @@ func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r
 	})
 
 	// send a 202 Accepted response and confirmation message to the client
-	env := envelope{"message": "an email would be sent to you containing password reset instructions."}
+	env := envelope{"message": "if the email address belongs to an activated account, an email will be sent to it containing password reset instructions."}
 
 	err = app.writeJSON(w, http.StatusAccepted, env, nil)
 	if err != nil {
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"time"
+
+	"api.cinevie.jpranata.tech/internal/data"
+	"api.cinevie.jpranata.tech/internal/totp"
+	"api.cinevie.jpranata.tech/internal/validator"
+
+	"rsc.io/qr"
+)
+
+// the issuer shown next to the account in the authenticator apps
+const totpIssuer = "Cinevie"
+
+// check either the code of the authenticator app or an unused recovery code,
+// both can only be used once
+func (app *application) checkSecondFactor(userID int64, code, recoveryCode string) (bool, error) {
+	if code == "" {
+		err := app.models.TwoFactor.UseRecoveryCode(userID, recoveryCode)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				return false, nil
+			default:
+				return false, err
+			}
+		}
+
+		return true, nil
+	}
+
+	return app.checkTOTPCode(userID, code)
+}
+
+// check the code against the user's secret and record its time step
+// so it can't be replayed
+func (app *application) checkTOTPCode(userID int64, code string) (bool, error) {
+	secret, err := app.models.TwoFactor.GetTOTP(userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	step, valid := totp.Validate(code, secret.Secret, time.Now())
+	if !valid {
+		return false, nil
+	}
+
+	return app.models.TwoFactor.UseTOTPStep(userID, step)
+}
+
+// POST method with /v1/users/me/mfa/totp endpoint to start the enrollment of an
+// authenticator app, it only becomes required once confirmed with a valid code
+func (app *application) createTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+
+		return
+	}
+
+	existing, err := app.models.TwoFactor.GetTOTP(user.ID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+
+		return
+	}
+
+	if existing != nil && existing.IsConfirmed() {
+		v := validator.New()
+		v.AddError("totp", "two-factor authentication has already been enabled.")
+		app.failedValidationResponse(w, r, v.Errors)
+
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+
+		return
+	}
+
+	err = app.models.TwoFactor.SetTOTP(user.ID, secret)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+
+		return
+	}
+
+	uri := totp.URI(totpIssuer, user.Email, secret)
+
+	code, err := qr.Encode(uri, qr.M)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+
+		return
+	}
+
+	env := envelope{
+		"totp": envelope{
+			"secret":  secret,
+			"uri":     uri,
+			"qr_code": "data:image/png;base64," + base64.StdEncoding.EncodeToString(code.PNG()),
+		},
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// POST method with /v1/users/me/mfa/totp/confirm endpoint to finish the enrollment
+// with a code of the authenticator app, the recovery codes are only sent in this response
+func (app *application) confirmTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTOTPCode(v, input.Code); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	secret, err := app.models.TwoFactor.GetTOTP(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("totp", "the enrollment must be started first.")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	if secret.IsConfirmed() {
+		v.AddError("totp", "two-factor authentication has already been enabled.")
+		app.failedValidationResponse(w, r, v.Errors)
+
+		return
+	}
+
+	valid, err := app.checkTOTPCode(user.ID, input.Code)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+
+		return
+	}
+
+	if !valid {
+		app.invalidSecondFactorResponse(w, r)
+
+		return
+	}
+
+	err = app.models.TwoFactor.ConfirmTOTP(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("totp", "two-factor authentication has already been enabled.")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	codes, err := data.GenerateRecoveryCodes()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+
+		return
+	}
+
+	err = app.models.TwoFactor.ReplaceRecoveryCodes(user.ID, codes)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+
+		return
+	}
+
+	env := envelope{
+		"message":        "two-factor authentication has been enabled, store the recovery codes in a safe place.",
+		"recovery_codes": codes,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// POST method with /v1/users/me/mfa/recovery-codes endpoint to replace
+// the recovery codes with new ones
+func (app *application) createRecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTOTPCode(v, input.Code); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	secret, err := app.models.TwoFactor.GetTOTP(user.ID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+
+		return
+	}
+
+	if secret == nil || !secret.IsConfirmed() {
+		v.AddError("totp", "two-factor authentication must be enabled first.")
+		app.failedValidationResponse(w, r, v.Errors)
+
+		return
+	}
+
+	valid, err := app.checkTOTPCode(user.ID, input.Code)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+
+		return
+	}
+
+	if !valid {
+		app.invalidSecondFactorResponse(w, r)
+
+		return
+	}
+
+	codes, err := data.GenerateRecoveryCodes()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+
+		return
+	}
+
+	err = app.models.TwoFactor.ReplaceRecoveryCodes(user.ID, codes)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"recovery_codes": codes}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// DELETE method with /v1/users/me/mfa/totp endpoint to disable two-factor
+// authentication, both the password and a code (or a recovery code) are required
+func (app *application) deleteTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+
+		return
+	}
+
+	var input struct {
+		Password     string `json:"password"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+
+		return
+	}
+
+	v := validator.New()
+
+	data.ValidatePasswordPlaintext(v, input.Password)
+	v.Check(input.Code != "" || input.RecoveryCode != "", "code", "must be provided.")
+
+	if input.Code != "" {
+		data.ValidateTOTPCode(v, input.Code)
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+
+		return
+	}
+
+	match, err := user.Password.Matches(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+
+		return
+	}
+
+	if !match {
+		v.AddError("password", "is incorrect.")
+		app.failedValidationResponse(w, r, v.Errors)
+
+		return
+	}
+
+	valid, err := app.checkSecondFactor(user.ID, input.Code, input.RecoveryCode)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+
+		return
+	}
+
+	if !valid {
+		app.invalidSecondFactorResponse(w, r)
+
+		return
+	}
+
+	err = app.models.TwoFactor.Delete(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "two-factor authentication has been disabled."}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
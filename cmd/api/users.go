@@ -2,7 +2,9 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"api.cinevie.jpranata.tech/internal/data"
@@ -12,9 +14,10 @@ import (
 func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Request) {
 	// an anonymous struct to hold the expected data from the request body
 	var input struct {
-		Name     string `json:"name"`
-		Email    string `json:"email"`
-		Password string `json:"password"`
+		Name       string `json:"name"`
+		Email      string `json:"email"`
+		Password   string `json:"password"`
+		InviteCode string `json:"invite_code"`
 	}
 
 	// parse the request body to the anonymous struct
@@ -42,13 +45,40 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 
 	v := validator.New()
 
-	// validate the user struct and return the error messages
-	if data.ValidateUser(v, user); !v.Valid() {
+	// validate the user struct and the strength of the password
+	data.ValidateUser(v, user)
+	app.passwords.Validate(v, input.Password, user)
+
+	// in the invite mode the users can only register with an invitation,
+	// the invitations are honored in the open mode as well
+	if app.config.users.registration == "invite" {
+		v.Check(input.InviteCode != "", "invite_code", "must be provided.")
+	}
+
+	if input.InviteCode != "" {
+		data.ValidateInvitationCode(v, input.InviteCode)
+	}
+
+	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 
 		return
 	}
 
+	var invitation *data.Invitation
+
+	if input.InviteCode != "" {
+		var ok bool
+
+		invitation, ok = app.checkInvitation(w, r, v, input.InviteCode, user.Email)
+		if !ok {
+			return
+		}
+
+		// the invitation has been emailed to the address so it doesn't need the activation
+		user.Activated = true
+	}
+
 	// inert the user data into database
 	err = app.models.Users.Insert(user)
 	if err != nil {
@@ -63,14 +93,21 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	// add the "movies:read" permission for the new registered user
-	err = app.models.Permissions.AddForUser(user.ID, "movies:read")
+	// assign the default role (viewer unless configured otherwise)
+	// to the new registered user
+	err = app.models.Roles.AddForUser(user.ID, app.config.users.defaultRole)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 
 		return
 	}
 
+	if invitation != nil {
+		app.acceptInvitation(w, r, v, invitation, user)
+
+		return
+	}
+
 	// after the user record has been created in the database,
 	// generate new activation token for user
 	token, err := app.models.Tokens.New(user.ID, 3*time.Hour, data.ScopeActivation)
@@ -208,6 +245,13 @@ func (app *application) updateUserPasswordHandler(w http.ResponseWriter, r *http
 		return
 	}
 
+	// the name and email address aren't known before retrieving the user
+	if app.passwords.Validate(v, input.Password, user); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+
+		return
+	}
+
 	// set the new password for the user
 	err = user.Password.Set(input.Password)
 	if err != nil {
@@ -244,3 +288,561 @@ func (app *application) updateUserPasswordHandler(w http.ResponseWriter, r *http
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// GET method with /v1/users/me endpoint to show the authenticated user
+// along with their permissions
+func (app *application) showCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+
+		return
+	}
+
+	permissions := app.contextGetPermissions(r)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user, "permissions": permissions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// PATCH method with /v1/users/me endpoint to update the authenticated user's profile
+func (app *application) updateCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+
+		return
+	}
+
+	var input struct {
+		Name *string `json:"name"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+
+		return
+	}
+
+	if input.Name != nil {
+		user.Name = *input.Name
+	}
+
+	v := validator.New()
+
+	if data.ValidateUser(v, user); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+
+		return
+	}
+
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// PUT method with /v1/users/me/password endpoint to change the password of
+// the authenticated user, every other session of the user is logged out
+func (app *application) updateCurrentUserPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+
+		return
+	}
+
+	var input struct {
+		CurrentPassword string `json:"current_password"`
+		Password        string `json:"password"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.CurrentPassword != "", "current_password", "must be provided.")
+	app.passwords.Validate(v, input.Password, user)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+
+		return
+	}
+
+	// check the current password before accepting the new one
+	match, err := user.Password.Matches(input.CurrentPassword)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+
+		return
+	}
+
+	if !match {
+		v.AddError("current_password", "is incorrect.")
+		app.failedValidationResponse(w, r, v.Errors)
+
+		return
+	}
+
+	err = user.Password.Set(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+
+		return
+	}
+
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	// keep the current session, there is none when an API key is being used
+	var currentSessionID int64
+	if session := app.contextGetSession(r); session != nil {
+		currentSessionID = session.ID
+	}
+
+	// log out the other sessions and invalidate any pending password reset
+	_, err = app.models.Sessions.DeleteAllForUserExcept(user.ID, currentSessionID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+
+		return
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(data.ScopePasswordReset, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+
+		return
+	}
+
+	env := envelope{"message": "your password was successfully changed."}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// POST method with /v1/users/me/email endpoint to request changing the email
+// address, the change only takes effect once confirmed from the new address
+func (app *application) createEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+
+		return
+	}
+
+	var input struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+
+		return
+	}
+
+	v := validator.New()
+
+	data.ValidateEmail(v, input.Email)
+	v.Check(input.Password != "", "password", "must be provided.")
+	v.Check(!strings.EqualFold(input.Email, user.Email), "email", "must be different from the current email address.")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+
+		return
+	}
+
+	// re-confirm the password since the email address controls the account
+	match, err := user.Password.Matches(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+
+		return
+	}
+
+	if !match {
+		v.AddError("password", "is incorrect.")
+		app.failedValidationResponse(w, r, v.Errors)
+
+		return
+	}
+
+	// the email address is checked again when the change is confirmed
+	_, err = app.models.Users.GetByEmail(input.Email)
+	if err == nil {
+		v.AddError("email", "a user with this email address already exists.")
+		app.failedValidationResponse(w, r, v.Errors)
+
+		return
+	}
+
+	if !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+
+		return
+	}
+
+	// only the latest request of the user could be confirmed
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeEmailChange, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+
+		return
+	}
+
+	err = app.models.Users.SetPendingEmail(user.ID, input.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+
+		return
+	}
+
+	token, err := app.models.Tokens.New(user.ID, time.Hour, data.ScopeEmailChange)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+
+		return
+	}
+
+	// send the confirmation token to the new address and notify the old one
+	app.background(func() {
+		data := map[string]interface{}{
+			"emailChangeToken": token.Plaintext,
+			"newEmail":         input.Email,
+			"userName":         user.Name,
+		}
+
+		err := app.mailer.Send(input.Email, "token_email_change.tmpl", data)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+
+		err = app.mailer.Send(user.Email, "user_email_change_notice.tmpl", data)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+
+	env := envelope{"message": "an email will be sent to the new address containing confirmation instructions."}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// PUT method with /v1/users/email endpoint to confirm the email change
+// using the token sent to the new address
+func (app *application) confirmEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(data.ScopeEmailChange, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired email change token.")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	email, err := app.models.Users.GetPendingEmail(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired email change token.")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	user.Email = email
+
+	// the address might have been registered since the change was requested
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateEmail):
+			v.AddError("email", "a user with this email address already exists.")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+
+		return
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeEmailChange, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+
+		return
+	}
+
+	err = app.models.Users.DeletePendingEmail(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// DELETE method with /v1/users/me endpoint, the account is logged out
+// everywhere, its API keys are revoked and it's permanently deleted once the grace period is over
+// unless the user logs in again before then
+func (app *application) deleteCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+
+		return
+	}
+
+	var input struct {
+		Password string `json:"password"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+
+		return
+	}
+
+	v := validator.New()
+
+	if v.Check(input.Password != "", "password", "must be provided."); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+
+		return
+	}
+
+	match, err := user.Password.Matches(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+
+		return
+	}
+
+	if !match {
+		v.AddError("password", "is incorrect.")
+		app.failedValidationResponse(w, r, v.Errors)
+
+		return
+	}
+
+	err = app.models.Users.ScheduleDeletion(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+
+		return
+	}
+
+	err = app.models.Sessions.DeleteAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+
+		return
+	}
+
+	// the keys would otherwise keep working until the account is gone
+	err = app.models.APIKeys.DeleteAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+
+		return
+	}
+
+	app.clearTokenCookies(w)
+
+	deletionTime := time.Now().Add(app.config.users.deletionGracePeriod)
+
+	env := envelope{
+		"message":     "your account will be permanently deleted, log in again before the deletion time to cancel it.",
+		"deletion_at": deletionTime.Truncate(time.Second),
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// GET method with /v1/users/me/export endpoint to download everything
+// being held about the authenticated user as a JSON document
+func (app *application) exportCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+
+		return
+	}
+
+	// the permissions in the context are narrowed down when the request
+	// has been authenticated with an API key
+	permissions, err := app.userPermissions(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+
+		return
+	}
+
+	tokens, err := app.models.Tokens.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+
+		return
+	}
+
+	apiKeys, err := app.models.APIKeys.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+
+		return
+	}
+
+	var currentSessionID int64
+	if session := app.contextGetSession(r); session != nil {
+		currentSessionID = session.ID
+	}
+
+	sessions, err := app.models.Sessions.GetAllForUser(user.ID, currentSessionID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+
+		return
+	}
+
+	// the secret itself isn't exported, only whether it's enabled
+	totp, err := app.models.TwoFactor.GetTOTP(user.ID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+
+		return
+	}
+
+	identities, err := app.models.Identities.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+
+		return
+	}
+
+	// the pending email change is optional
+	pendingEmail, err := app.models.Users.GetPendingEmail(user.ID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+
+		return
+	}
+
+	tags, err := app.models.Tags.GetAllActivityForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+
+		return
+	}
+
+	comments, err := app.models.Comments.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+
+		return
+	}
+
+	reports, err := app.models.Comments.GetAllReportsForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+
+		return
+	}
+
+	env := envelope{
+		"exported_at":     time.Now().UTC().Truncate(time.Second),
+		"user":            user,
+		"pending_email":   pendingEmail,
+		"permissions":     permissions,
+		"sessions":        sessions,
+		"two_factor":      totp != nil && totp.IsConfirmed(),
+		"identities":      identities,
+		"tokens":          tokens,
+		"api_keys":        apiKeys,
+		"tags":            tags,
+		"comments":        comments,
+		"comment_reports": reports,
+	}
+
+	// ask the browser to save the response as a file
+	headers := make(http.Header)
+	headers.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="cinevie-export-%d.json"`, user.ID))
+
+	err = app.writeJSON(w, http.StatusOK, env, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
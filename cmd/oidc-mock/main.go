@@ -0,0 +1,297 @@
+// a minimal OpenID Connect issuer for trying the single sign-on locally,
+// every authorization request is approved without asking the user
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const keyID = "mock"
+
+type config struct {
+	port         int
+	issuer       string
+	clientID     string
+	clientSecret string
+	email        string
+	name         string
+	unverified   bool
+}
+
+// an issued authorization code waiting to be exchanged
+type grant struct {
+	clientID      string
+	redirectURI   string
+	codeChallenge string
+	nonce         string
+	email         string
+	expiry        time.Time
+}
+
+type issuer struct {
+	config config
+	key    *rsa.PrivateKey
+
+	mu     sync.Mutex
+	grants map[string]grant
+}
+
+func main() {
+	var cfg config
+
+	flag.IntVar(&cfg.port, "port", 4010, "Mock issuer port.")
+	flag.StringVar(&cfg.issuer, "issuer", "", "Issuer URL (defaults to http://localhost:<port>).")
+	flag.StringVar(&cfg.clientID, "client-id", "cinevie", "Client ID the API is registered with.")
+	flag.StringVar(&cfg.clientSecret, "client-secret", "secret", "Client secret the API is registered with.")
+	flag.StringVar(&cfg.email, "email", "alice@example.com", "Email of the logged in user, overridden by the login_hint parameter.")
+	flag.StringVar(&cfg.name, "name", "Alice", "Name of the logged in user.")
+	flag.BoolVar(&cfg.unverified, "unverified", false, "Report the email address as unverified.")
+
+	flag.Parse()
+
+	if cfg.issuer == "" {
+		cfg.issuer = fmt.Sprintf("http://localhost:%d", cfg.port)
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	iss := &issuer{
+		config: cfg,
+		key:    key,
+		grants: make(map[string]grant),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", iss.discoveryHandler)
+	mux.HandleFunc("/jwks", iss.jwksHandler)
+	mux.HandleFunc("/authorize", iss.authorizeHandler)
+	mux.HandleFunc("/token", iss.tokenHandler)
+
+	log.Printf("mock oidc issuer %s (client %s|%s)", cfg.issuer, cfg.clientID, cfg.clientSecret)
+
+	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", cfg.port), mux))
+}
+
+func (iss *issuer) discoveryHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                iss.config.issuer,
+		"authorization_endpoint":                iss.config.issuer + "/authorize",
+		"token_endpoint":                        iss.config.issuer + "/token",
+		"jwks_uri":                              iss.config.issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (iss *issuer) jwksHandler(w http.ResponseWriter, r *http.Request) {
+	pub := iss.key.PublicKey
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{
+			{
+				"kty": "RSA",
+				"kid": keyID,
+				"use": "sig",
+				"alg": "RS256",
+				"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			},
+		},
+	})
+}
+
+// approve the request right away and redirect back with a new code
+func (iss *issuer) authorizeHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+
+	switch {
+	case qs.Get("response_type") != "code":
+		http.Error(w, "unsupported response_type", http.StatusBadRequest)
+		return
+	case qs.Get("client_id") != iss.config.clientID:
+		http.Error(w, "unknown client_id", http.StatusBadRequest)
+		return
+	case qs.Get("code_challenge_method") != "S256" || qs.Get("code_challenge") == "":
+		http.Error(w, "S256 code_challenge required", http.StatusBadRequest)
+		return
+	}
+
+	redirectURI, err := url.Parse(qs.Get("redirect_uri"))
+	if err != nil || redirectURI.Host == "" {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	email := qs.Get("login_hint")
+	if email == "" {
+		email = iss.config.email
+	}
+
+	code, err := randomString()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	iss.mu.Lock()
+	iss.grants[code] = grant{
+		clientID:      qs.Get("client_id"),
+		redirectURI:   qs.Get("redirect_uri"),
+		codeChallenge: qs.Get("code_challenge"),
+		nonce:         qs.Get("nonce"),
+		email:         email,
+		expiry:        time.Now().Add(time.Minute),
+	}
+	iss.mu.Unlock()
+
+	params := redirectURI.Query()
+	params.Set("code", code)
+	params.Set("state", qs.Get("state"))
+	redirectURI.RawQuery = params.Encode()
+
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+// exchange the code for a signed ID token after checking the client
+// credentials and the PKCE code verifier
+func (iss *issuer) tokenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		tokenError(w, "invalid_request")
+		return
+	}
+
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok {
+		clientID, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+
+	if clientID != iss.config.clientID || subtle.ConstantTimeCompare([]byte(clientSecret), []byte(iss.config.clientSecret)) != 1 {
+		tokenError(w, "invalid_client")
+		return
+	}
+
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		tokenError(w, "unsupported_grant_type")
+		return
+	}
+
+	// a code can only be exchanged once
+	iss.mu.Lock()
+	g, found := iss.grants[r.PostForm.Get("code")]
+	delete(iss.grants, r.PostForm.Get("code"))
+	iss.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	switch {
+	case !found || time.Now().After(g.expiry):
+		tokenError(w, "invalid_grant")
+		return
+	case g.clientID != clientID || g.redirectURI != r.PostForm.Get("redirect_uri"):
+		tokenError(w, "invalid_grant")
+		return
+	case challenge != g.codeChallenge:
+		tokenError(w, "invalid_grant")
+		return
+	}
+
+	now := time.Now()
+
+	// the email doubles as the subject so logging in again finds the same identity
+	claims := map[string]interface{}{
+		"iss":            iss.config.issuer,
+		"sub":            strings.ToLower(g.email),
+		"aud":            clientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"nonce":          g.nonce,
+		"email":          g.email,
+		"email_verified": !iss.config.unverified,
+		"name":           iss.config.name,
+	}
+
+	idToken, err := iss.sign(claims)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": idToken,
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
+
+func (iss *issuer) sign(claims map[string]interface{}) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": keyID})
+	if err != nil {
+		return "", err
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	digest := sha256.Sum256([]byte(signingInput))
+
+	signature, err := rsa.SignPKCS1v15(rand.Reader, iss.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 32)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func tokenError(w http.ResponseWriter, code string) {
+	writeJSON(w, http.StatusBadRequest, map[string]string{"error": code})
+}
+
+func writeJSON(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	json.NewEncoder(w).Encode(data)
+}
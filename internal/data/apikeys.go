@@ -0,0 +1,265 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"api.cinevie.jpranata.tech/internal/validator"
+
+	"github.com/lib/pq"
+)
+
+// every API key starts with the prefix so it can be told apart
+// from the authentication tokens (and spotted by secret scanners)
+const APIKeyPrefix = "cvk_"
+
+// long-lived personal API key for automation, it only carries the subset
+// of the owner's permissions which has been chosen when creating it
+type APIKey struct {
+	ID          int64       `json:"id"`
+	UserID      int64       `json:"-"`
+	Name        string      `json:"name"`
+	Plaintext   string      `json:"key,omitempty"`
+	Prefix      string      `json:"prefix"`
+	Hash        []byte      `json:"-"`
+	Permissions Permissions `json:"permissions"`
+	CreatedAt   time.Time   `json:"created_at"`
+	Expiry      *time.Time  `json:"expiry"`
+	LastUsedAt  *time.Time  `json:"last_used_at"`
+	LastUsedIP  *string     `json:"last_used_ip"`
+}
+
+// the plaintext key is only known when it's generated, afterwards
+// the prefix (first characters of the key) helps the user to recognize it
+func generateAPIKey(userID int64, name string, permissions Permissions, expiry *time.Time) (*APIKey, error) {
+	key := &APIKey{
+		UserID:      userID,
+		Name:        name,
+		Permissions: permissions,
+		Expiry:      expiry,
+	}
+
+	randomBytes := make([]byte, 20)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	key.Plaintext = APIKeyPrefix + base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+	key.Prefix = key.Plaintext[:len(APIKeyPrefix)+6]
+
+	hash := sha256.Sum256([]byte(key.Plaintext))
+	key.Hash = hash[:]
+
+	return key, nil
+}
+
+func IsAPIKey(plaintext string) bool {
+	return strings.HasPrefix(plaintext, APIKeyPrefix)
+}
+
+func ValidateAPIKeyPlaintext(v *validator.Validator, plaintext string) {
+	v.Check(plaintext != "", "key", "must be provided.")
+	v.Check(len(plaintext) == len(APIKeyPrefix)+32, "key", "must be 36 bytes long.")
+}
+
+func ValidateAPIKey(v *validator.Validator, key *APIKey) {
+	v.Check(key.Name != "", "name", "must be provided.")
+	v.Check(len(key.Name) <= 100, "name", "must not be more than 100 bytes long.")
+
+	v.Check(len(key.Permissions) >= 1, "permissions", "must contain at least one permission.")
+	v.Check(validator.Unique(key.Permissions), "permissions", "must not contain duplicate values.")
+
+	if key.Expiry != nil {
+		v.Check(key.Expiry.After(time.Now()), "expiry", "must be in the future.")
+	}
+}
+
+// APIKeyModel type
+type APIKeyModel struct {
+	DB *sql.DB
+}
+
+// generate a new API key and insert it in the api_keys table, the returned
+// struct holds the plaintext key which must be shown to the user once
+func (m APIKeyModel) New(userID int64, name string, permissions Permissions, expiry *time.Time) (*APIKey, error) {
+	key, err := generateAPIKey(userID, name, permissions, expiry)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+    INSERT INTO api_keys (user_id, name, prefix, hash, permissions, expiry)
+    VALUES ($1, $2, $3, $4, $5, $6)
+    RETURNING id, created_at
+  `
+
+	args := []interface{}{key.UserID, key.Name, key.Prefix, key.Hash, pq.Array(key.Permissions), key.Expiry}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, query, args...).Scan(&key.ID, &key.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// retrieve the API key which hasn't expired yet by its plaintext
+func (m APIKeyModel) GetForKey(plaintext string) (*APIKey, error) {
+	hash := sha256.Sum256([]byte(plaintext))
+
+	query := `
+    SELECT id, user_id, name, prefix, permissions, created_at, expiry, last_used_at, last_used_ip
+    FROM api_keys
+    WHERE hash = $1
+    AND (expiry IS NULL OR expiry > $2)
+  `
+
+	var key APIKey
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, hash[:], time.Now()).Scan(
+		&key.ID,
+		&key.UserID,
+		&key.Name,
+		&key.Prefix,
+		pq.Array(&key.Permissions),
+		&key.CreatedAt,
+		&key.Expiry,
+		&key.LastUsedAt,
+		&key.LastUsedIP,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &key, nil
+}
+
+// returns every API key of the user including the expired ones
+func (m APIKeyModel) GetAllForUser(userID int64) ([]*APIKey, error) {
+	query := `
+    SELECT id, user_id, name, prefix, permissions, created_at, expiry, last_used_at, last_used_ip
+    FROM api_keys
+    WHERE user_id = $1
+    ORDER BY created_at DESC, id DESC
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	keys := []*APIKey{}
+
+	for rows.Next() {
+		var key APIKey
+
+		err := rows.Scan(
+			&key.ID,
+			&key.UserID,
+			&key.Name,
+			&key.Prefix,
+			pq.Array(&key.Permissions),
+			&key.CreatedAt,
+			&key.Expiry,
+			&key.LastUsedAt,
+			&key.LastUsedIP,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, &key)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// record when and from where the key has been used, the timestamp is only
+// updated once a minute to avoid a write on every request
+func (m APIKeyModel) Touch(id int64, ip string) error {
+	query := `
+    UPDATE api_keys
+    SET last_used_at = NOW(), last_used_ip = $2
+    WHERE id = $1
+    AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute' OR last_used_ip <> $2)
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, id, ip)
+
+	return err
+}
+
+// revoke the API key, the user id makes sure only the owner can delete it
+func (m APIKeyModel) Delete(id, userID int64) error {
+	query := `
+    DELETE FROM api_keys
+    WHERE id = $1 AND user_id = $2
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// revoke every API key of the user
+// (*when the account might have been compromised)
+func (m APIKeyModel) DeleteAllForUser(userID int64) error {
+	query := `
+    DELETE FROM api_keys
+    WHERE user_id = $1
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID)
+
+	return err
+}
@@ -0,0 +1,514 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"api.cinevie.jpranata.tech/internal/validator"
+
+	"github.com/lib/pq"
+)
+
+// how long the author is allowed to edit their comment after posting it
+const CommentEditWindow = 15 * time.Minute
+
+var (
+	ErrDuplicateReport = errors.New("duplicate report")
+)
+
+// a comment on a movie, top-level comments have no ParentID and
+// the replies are nested in the Replies slice when returned as a thread
+type Comment struct {
+	ID        int64      `json:"id"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	MovieID   int64      `json:"movie_id"`
+	UserID    *int64     `json:"user_id"`
+	ParentID  *int64     `json:"parent_id,omitempty"`
+	Body      string     `json:"body"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	Version   int32      `json:"-"`
+	Replies   []*Comment `json:"replies,omitempty"`
+}
+
+// a comment waiting in the moderation queue along with its open reports
+type ReportedComment struct {
+	Comment
+	Reports int      `json:"reports"`
+	Reasons []string `json:"reasons"`
+}
+
+// check if the comment has been soft-deleted
+func (c *Comment) IsDeleted() bool {
+	return c.DeletedAt != nil
+}
+
+// check if the comment is still within its edit window
+func (c *Comment) IsEditable() bool {
+	return time.Since(c.CreatedAt) <= CommentEditWindow
+}
+
+func ValidateComment(v *validator.Validator, comment *Comment) {
+	v.Check(comment.Body != "", "body", "must be provided.")
+	v.Check(len(comment.Body) <= 5000, "body", "must not be more than 5000 bytes long.")
+}
+
+func ValidateCommentReport(v *validator.Validator, reason string) {
+	v.Check(reason != "", "reason", "must be provided.")
+	v.Check(len(reason) <= 500, "reason", "must not be more than 500 bytes long.")
+}
+
+// CommentModel type
+type CommentModel struct {
+	DB *sql.DB
+}
+
+func (m CommentModel) Insert(comment *Comment) error {
+	query := `
+    INSERT INTO comments (movie_id, user_id, parent_id, body)
+    VALUES ($1, $2, $3, $4)
+    RETURNING id, created_at, updated_at, version
+  `
+
+	args := []interface{}{
+		comment.MovieID,
+		comment.UserID,
+		comment.ParentID,
+		comment.Body,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&comment.ID, &comment.CreatedAt, &comment.UpdatedAt, &comment.Version)
+}
+
+func (m CommentModel) Get(id int64) (*Comment, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+    SELECT id, created_at, updated_at, movie_id, user_id, parent_id, body, deleted_at, version
+    FROM comments
+    WHERE id = $1
+  `
+
+	var comment Comment
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&comment.ID,
+		&comment.CreatedAt,
+		&comment.UpdatedAt,
+		&comment.MovieID,
+		&comment.UserID,
+		&comment.ParentID,
+		&comment.Body,
+		&comment.DeletedAt,
+		&comment.Version,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &comment, nil
+}
+
+// returns a page of top-level comments for the movie with every reply
+// nested under its parent
+func (m CommentModel) GetAllForMovie(movieID int64, filters Filters) ([]*Comment, Metadata, error) {
+	query := fmt.Sprintf(`
+    SELECT count(*) OVER(), id, created_at, updated_at, movie_id, user_id, parent_id, body, deleted_at, version
+    FROM comments
+    WHERE movie_id = $1 AND parent_id IS NULL
+    ORDER BY %s %s, id ASC
+    LIMIT $2 OFFSET $3
+  `, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	comments := []*Comment{}
+
+	// keep track of every comment in the threads by their id
+	// so the replies can be attached to their parent
+	threads := make(map[int64]*Comment)
+	ids := []int64{}
+
+	for rows.Next() {
+		var comment Comment
+
+		err := rows.Scan(
+			&totalRecords,
+			&comment.ID,
+			&comment.CreatedAt,
+			&comment.UpdatedAt,
+			&comment.MovieID,
+			&comment.UserID,
+			&comment.ParentID,
+			&comment.Body,
+			&comment.DeletedAt,
+			&comment.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		comments = append(comments, &comment)
+		threads[comment.ID] = &comment
+		ids = append(ids, comment.ID)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	if len(ids) == 0 {
+		return comments, metadata, nil
+	}
+
+	// walk down the reply tree of the top-level comments on this page,
+	// the parents are always returned before their replies
+	query = `
+    WITH RECURSIVE replies AS (
+      SELECT id, created_at, updated_at, movie_id, user_id, parent_id, body, deleted_at, version, 1 AS depth
+      FROM comments
+      WHERE parent_id = ANY($1)
+      UNION ALL
+      SELECT comments.id, comments.created_at, comments.updated_at, comments.movie_id, comments.user_id,
+        comments.parent_id, comments.body, comments.deleted_at, comments.version, replies.depth + 1
+      FROM comments
+      INNER JOIN replies ON comments.parent_id = replies.id
+    )
+    SELECT id, created_at, updated_at, movie_id, user_id, parent_id, body, deleted_at, version
+    FROM replies
+    ORDER BY depth ASC, created_at ASC, id ASC
+  `
+
+	replies, err := m.DB.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer replies.Close()
+
+	for replies.Next() {
+		var comment Comment
+
+		err := replies.Scan(
+			&comment.ID,
+			&comment.CreatedAt,
+			&comment.UpdatedAt,
+			&comment.MovieID,
+			&comment.UserID,
+			&comment.ParentID,
+			&comment.Body,
+			&comment.DeletedAt,
+			&comment.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		if parent, ok := threads[*comment.ParentID]; ok {
+			parent.Replies = append(parent.Replies, &comment)
+		}
+
+		threads[comment.ID] = &comment
+	}
+
+	if err = replies.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return comments, metadata, nil
+}
+
+// update the body of the comment checking against the version
+func (m CommentModel) Update(comment *Comment) error {
+	query := `
+    UPDATE comments
+    SET body = $1, updated_at = NOW(), version = version + 1
+    WHERE id = $2 AND version = $3 AND deleted_at IS NULL
+    RETURNING updated_at, version
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, comment.Body, comment.ID, comment.Version).Scan(&comment.UpdatedAt, &comment.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// soft-delete the comment by blanking its body, the record is kept
+// so the replies remain in their thread
+func (m CommentModel) Delete(id int64) error {
+	query := `
+    UPDATE comments
+    SET body = '', deleted_at = NOW(), version = version + 1
+    WHERE id = $1 AND deleted_at IS NULL
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// a user can only have one unresolved report of a specific comment
+func (m CommentModel) Report(commentID, userID int64, reason string) error {
+	query := `
+    INSERT INTO comments_reports (comment_id, user_id, reason)
+    VALUES ($1, $2, $3)
+    ON CONFLICT DO NOTHING
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, commentID, userID, reason)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrDuplicateReport
+	}
+
+	return nil
+}
+
+// the moderation queue, comments with unresolved reports
+// ordered by the number of reports they have received
+func (m CommentModel) GetAllReported(filters Filters) ([]*ReportedComment, Metadata, error) {
+	query := fmt.Sprintf(`
+    SELECT count(*) OVER(), comments.id, comments.created_at, comments.updated_at, comments.movie_id, comments.user_id,
+      comments.parent_id, comments.body, comments.deleted_at, comments.version,
+      count(comments_reports.user_id) AS reports, array_agg(comments_reports.reason ORDER BY comments_reports.created_at)
+    FROM comments
+    INNER JOIN comments_reports ON comments_reports.comment_id = comments.id
+    WHERE comments_reports.resolved_at IS NULL
+    GROUP BY comments.id
+    ORDER BY %s %s, comments.id ASC
+    LIMIT $1 OFFSET $2
+  `, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	comments := []*ReportedComment{}
+
+	for rows.Next() {
+		var comment ReportedComment
+
+		err := rows.Scan(
+			&totalRecords,
+			&comment.ID,
+			&comment.CreatedAt,
+			&comment.UpdatedAt,
+			&comment.MovieID,
+			&comment.UserID,
+			&comment.ParentID,
+			&comment.Body,
+			&comment.DeletedAt,
+			&comment.Version,
+			&comment.Reports,
+			pq.Array(&comment.Reasons),
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		comments = append(comments, &comment)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return comments, metadata, nil
+}
+
+// mark every open report of the comment as resolved
+func (m CommentModel) ResolveReports(commentID int64) error {
+	query := `
+    UPDATE comments_reports
+    SET resolved_at = NOW()
+    WHERE comment_id = $1 AND resolved_at IS NULL
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, commentID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// a report made by a user on a comment
+type CommentReport struct {
+	CommentID  int64      `json:"comment_id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Reason     string     `json:"reason"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+}
+
+// returns every comment written by the user, including the deleted ones
+func (m CommentModel) GetAllForUser(userID int64) ([]*Comment, error) {
+	query := `
+    SELECT id, created_at, updated_at, movie_id, user_id, parent_id, body, deleted_at, version
+    FROM comments
+    WHERE user_id = $1
+    ORDER BY created_at ASC, id ASC
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	comments := []*Comment{}
+
+	for rows.Next() {
+		var comment Comment
+
+		err := rows.Scan(
+			&comment.ID,
+			&comment.CreatedAt,
+			&comment.UpdatedAt,
+			&comment.MovieID,
+			&comment.UserID,
+			&comment.ParentID,
+			&comment.Body,
+			&comment.DeletedAt,
+			&comment.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		comments = append(comments, &comment)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return comments, nil
+}
+
+// returns every report the user has made
+func (m CommentModel) GetAllReportsForUser(userID int64) ([]*CommentReport, error) {
+	query := `
+    SELECT comment_id, created_at, reason, resolved_at
+    FROM comments_reports
+    WHERE user_id = $1
+    ORDER BY created_at ASC
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	reports := []*CommentReport{}
+
+	for rows.Next() {
+		var report CommentReport
+
+		err := rows.Scan(&report.CommentID, &report.CreatedAt, &report.Reason, &report.ResolvedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		reports = append(reports, &report)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return reports, nil
+}
@@ -0,0 +1,272 @@
+package data
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var ErrUnknownHashFormat = errors.New("unknown password hash format")
+
+// the supported password hashing algorithms
+const (
+	HashBcrypt   = "bcrypt"
+	HashArgon2id = "argon2id"
+)
+
+// the algorithm and cost the new password hashes are calculated with, the
+// stored hashes are upgraded to them the next time the user logs in
+// the memory budget (in KiB) caps the argon2id hashes calculated at once
+type HashParams struct {
+	Algorithm    string
+	BcryptCost   int
+	Argon2       Argon2Params
+	MemoryBudget uint32
+}
+
+// the cost of Argon2id, the memory is in KiB
+type Argon2Params struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// the defaults follow the second recommended option of RFC 9106
+var passwordHashParams = HashParams{
+	Algorithm:  HashArgon2id,
+	BcryptCost: 12,
+	Argon2: Argon2Params{
+		Memory:      64 * 1024,
+		Iterations:  3,
+		Parallelism: 2,
+		SaltLength:  16,
+		KeyLength:   32,
+	},
+	MemoryBudget: 256 * 1024,
+}
+
+// the memory of the argon2id hashes being calculated, a burst of logins
+// waits for its turn instead of running out of memory
+var argon2Memory = newMemoryLimiter(passwordHashParams.MemoryBudget)
+
+type memoryLimiter struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	budget uint32
+	used   uint32
+}
+
+func newMemoryLimiter(budget uint32) *memoryLimiter {
+	l := &memoryLimiter{budget: budget}
+	l.cond = sync.NewCond(&l.mu)
+
+	return l
+}
+
+// wait until the memory fits in the budget, a hash bigger than the
+// whole budget still runs once nothing else does
+func (l *memoryLimiter) acquire(memory uint32) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for l.used > 0 && uint64(l.used)+uint64(memory) > uint64(l.budget) {
+		l.cond.Wait()
+	}
+
+	l.used += memory
+}
+
+func (l *memoryLimiter) release(memory uint32) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.used -= memory
+	l.cond.Broadcast()
+}
+
+func (l *memoryLimiter) setBudget(budget uint32) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.budget = budget
+	l.cond.Broadcast()
+}
+
+// calculate the argon2id key within the memory budget
+func argon2IDKey(password, salt []byte, a Argon2Params, keyLength uint32) []byte {
+	argon2Memory.acquire(a.Memory)
+	defer argon2Memory.release(a.Memory)
+
+	return argon2.IDKey(password, salt, a.Iterations, a.Memory, a.Parallelism, keyLength)
+}
+
+// replace the parameters the new password hashes are calculated with
+func SetPasswordHashParams(params HashParams) error {
+	switch params.Algorithm {
+	case HashBcrypt:
+		if params.BcryptCost < bcrypt.MinCost || params.BcryptCost > bcrypt.MaxCost {
+			return fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+	case HashArgon2id:
+		if params.Argon2.Memory < 8*uint32(params.Argon2.Parallelism) || params.Argon2.Iterations < 1 || params.Argon2.Parallelism < 1 {
+			return errors.New("invalid argon2id parameters")
+		}
+	default:
+		return fmt.Errorf("unknown password hash algorithm %q", params.Algorithm)
+	}
+
+	if params.Argon2.SaltLength == 0 {
+		params.Argon2.SaltLength = passwordHashParams.Argon2.SaltLength
+	}
+
+	if params.Argon2.KeyLength == 0 {
+		params.Argon2.KeyLength = passwordHashParams.Argon2.KeyLength
+	}
+
+	if params.MemoryBudget == 0 {
+		params.MemoryBudget = passwordHashParams.MemoryBudget
+	}
+
+	passwordHashParams = params
+
+	argon2Memory.setBudget(params.MemoryBudget)
+
+	return nil
+}
+
+// hash the password with the current parameters
+func hashPassword(plaintextPassword string) ([]byte, error) {
+	params := passwordHashParams
+
+	if params.Algorithm == HashBcrypt {
+		return bcrypt.GenerateFromPassword([]byte(plaintextPassword), params.BcryptCost)
+	}
+
+	salt := make([]byte, params.Argon2.SaltLength)
+
+	_, err := rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	a := params.Argon2
+	key := argon2IDKey([]byte(plaintextPassword), salt, a, a.KeyLength)
+
+	// the PHC string format, the same one the reference implementation uses
+	hash := fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, a.Memory, a.Iterations, a.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	)
+
+	return []byte(hash), nil
+}
+
+// compare the password with the hash in whichever format it's stored
+func comparePassword(hash []byte, plaintextPassword string) (bool, error) {
+	switch {
+	case bytes.HasPrefix(hash, []byte("$argon2id$")):
+		a, salt, key, err := decodeArgon2idHash(hash)
+		if err != nil {
+			return false, err
+		}
+
+		other := argon2IDKey([]byte(plaintextPassword), salt, a, uint32(len(key)))
+
+		return subtle.ConstantTimeCompare(key, other) == 1, nil
+	case bytes.HasPrefix(hash, []byte("$2")):
+		err := bcrypt.CompareHashAndPassword(hash, []byte(plaintextPassword))
+		if err != nil {
+			switch {
+			case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
+				return false, nil
+			default:
+				return false, err
+			}
+		}
+
+		return true, nil
+	default:
+		return false, ErrUnknownHashFormat
+	}
+}
+
+// check whether the hash has been calculated with other than the current parameters
+func needsRehash(hash []byte) bool {
+	params := passwordHashParams
+
+	switch {
+	case bytes.HasPrefix(hash, []byte("$argon2id$")):
+		if params.Algorithm != HashArgon2id {
+			return true
+		}
+
+		a, salt, key, err := decodeArgon2idHash(hash)
+		if err != nil {
+			return true
+		}
+
+		return a.Memory != params.Argon2.Memory ||
+			a.Iterations != params.Argon2.Iterations ||
+			a.Parallelism != params.Argon2.Parallelism ||
+			uint32(len(salt)) != params.Argon2.SaltLength ||
+			uint32(len(key)) != params.Argon2.KeyLength
+	case bytes.HasPrefix(hash, []byte("$2")):
+		if params.Algorithm != HashBcrypt {
+			return true
+		}
+
+		cost, err := bcrypt.Cost(hash)
+
+		return err != nil || cost != params.BcryptCost
+	default:
+		return true
+	}
+}
+
+// parse the $argon2id$v=19$m=65536,t=3,p=2$<salt>$<key> string
+func decodeArgon2idHash(hash []byte) (Argon2Params, []byte, []byte, error) {
+	var a Argon2Params
+
+	parts := strings.Split(string(hash), "$")
+	if len(parts) != 6 {
+		return a, nil, nil, ErrUnknownHashFormat
+	}
+
+	var version int
+
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return a, nil, nil, ErrUnknownHashFormat
+	}
+
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &a.Memory, &a.Iterations, &a.Parallelism)
+	if err != nil {
+		return a, nil, nil, ErrUnknownHashFormat
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return a, nil, nil, ErrUnknownHashFormat
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return a, nil, nil, ErrUnknownHashFormat
+	}
+
+	a.SaltLength = uint32(len(salt))
+	a.KeyLength = uint32(len(key))
+
+	return a, salt, key, nil
+}
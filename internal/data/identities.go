@@ -0,0 +1,187 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// an account of an OpenID Connect provider linked to a user
+type Identity struct {
+	Provider  string    `json:"provider"`
+	Subject   string    `json:"subject"`
+	UserID    int64     `json:"-"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// the values which must be kept between redirecting the user to the
+// provider and the provider redirecting them back
+type OIDCState struct {
+	Provider     string
+	Nonce        string
+	CodeVerifier string
+}
+
+// IdentityModel type
+type IdentityModel struct {
+	DB *sql.DB
+}
+
+// link the identity to the user, linking the same identity again is a no-op
+func (m IdentityModel) Insert(identity *Identity) error {
+	query := `
+    INSERT INTO users_identities (provider, subject, user_id, email)
+    VALUES ($1, $2, $3, $4)
+    ON CONFLICT (provider, subject) DO NOTHING
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, identity.Provider, identity.Subject, identity.UserID, identity.Email)
+
+	return err
+}
+
+// retrieve the user the identity has been linked to
+func (m IdentityModel) GetUser(provider, subject string) (*User, error) {
+	query := `
+    SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.disabled, users.version
+    FROM users
+    INNER JOIN users_identities ON users_identities.user_id = users.id
+    WHERE users_identities.provider = $1 AND users_identities.subject = $2
+  `
+
+	var user User
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, provider, subject).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Disabled,
+		&user.Version,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
+// retrieve the identities linked to the user
+func (m IdentityModel) GetAllForUser(userID int64) ([]*Identity, error) {
+	query := `
+    SELECT provider, subject, user_id, email, created_at
+    FROM users_identities
+    WHERE user_id = $1
+    ORDER BY created_at ASC
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	identities := []*Identity{}
+
+	for rows.Next() {
+		var identity Identity
+
+		err := rows.Scan(&identity.Provider, &identity.Subject, &identity.UserID, &identity.Email, &identity.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		identities = append(identities, &identity)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return identities, nil
+}
+
+// store the pending authorization request, only the hash of the state is
+// stored since the state itself travels through the user's browser
+func (m IdentityModel) InsertState(statePlaintext string, state *OIDCState, ttl time.Duration) error {
+	hash := sha256.Sum256([]byte(statePlaintext))
+
+	query := `
+    INSERT INTO oidc_states (hash, provider, nonce, code_verifier, expiry)
+    VALUES ($1, $2, $3, $4, $5)
+  `
+
+	args := []interface{}{hash[:], state.Provider, state.Nonce, state.CodeVerifier, time.Now().Add(ttl)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, args...)
+
+	return err
+}
+
+// delete the pending authorization request and return it when it
+// hasn't expired and belongs to the provider, a state can only be used once
+func (m IdentityModel) ConsumeState(statePlaintext, provider string) (*OIDCState, error) {
+	hash := sha256.Sum256([]byte(statePlaintext))
+
+	query := `
+    DELETE FROM oidc_states
+    WHERE hash = $1 AND provider = $2 AND expiry > $3
+    RETURNING provider, nonce, code_verifier
+  `
+
+	var state OIDCState
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, hash[:], provider, time.Now()).Scan(&state.Provider, &state.Nonce, &state.CodeVerifier)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &state, nil
+}
+
+// delete the authorization requests the provider never redirected back
+func (m IdentityModel) DeleteExpiredStates() error {
+	query := `
+    DELETE FROM oidc_states
+    WHERE expiry < NOW()
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query)
+
+	return err
+}
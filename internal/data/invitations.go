@@ -0,0 +1,227 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"time"
+
+	"api.cinevie.jpranata.tech/internal/validator"
+
+	"github.com/lib/pq"
+)
+
+// an invitation to register with the email address, the invited user
+// is activated right away and receives the roles and permissions
+type Invitation struct {
+	ID          int64       `json:"id"`
+	Plaintext   string      `json:"invite_code,omitempty"`
+	Hash        []byte      `json:"-"`
+	Email       string      `json:"email"`
+	InvitedBy   *int64      `json:"invited_by"`
+	Roles       []string    `json:"roles"`
+	Permissions Permissions `json:"permissions"`
+	CreatedAt   time.Time   `json:"created_at"`
+	Expiry      time.Time   `json:"expiry"`
+	AcceptedAt  *time.Time  `json:"accepted_at"`
+}
+
+func ValidateInvitationCode(v *validator.Validator, code string) {
+	v.Check(code != "", "invite_code", "must be provided.")
+	v.Check(len(code) == 26, "invite_code", "must be 26 bytes long.")
+}
+
+func ValidateInvitation(v *validator.Validator, invitation *Invitation) {
+	ValidateEmail(v, invitation.Email)
+
+	v.Check(validator.Unique(invitation.Roles), "roles", "must not contain duplicate values.")
+	v.Check(validator.Unique(invitation.Permissions), "permissions", "must not contain duplicate values.")
+
+	v.Check(invitation.Expiry.After(time.Now()), "expiry", "must be in the future.")
+	v.Check(invitation.Expiry.Before(time.Now().Add(31*24*time.Hour)), "expiry", "must not be more than 30 days in the future.")
+}
+
+// InvitationModel type
+type InvitationModel struct {
+	DB *sql.DB
+}
+
+// generate the invite code and insert the invitation, the returned
+// struct holds the plaintext code which is only emailed to the invitee
+func (m InvitationModel) Insert(invitation *Invitation) error {
+	randomBytes := make([]byte, 16)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return err
+	}
+
+	invitation.Plaintext = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+
+	hash := sha256.Sum256([]byte(invitation.Plaintext))
+	invitation.Hash = hash[:]
+
+	query := `
+    INSERT INTO invitations (hash, email, invited_by, roles, permissions, expiry)
+    VALUES ($1, $2, $3, $4, $5, $6)
+    RETURNING id, created_at
+  `
+
+	args := []interface{}{
+		invitation.Hash,
+		invitation.Email,
+		invitation.InvitedBy,
+		pq.Array(invitation.Roles),
+		pq.Array(invitation.Permissions),
+		invitation.Expiry,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&invitation.ID, &invitation.CreatedAt)
+}
+
+// retrieve the pending invitation which hasn't expired yet by its code
+func (m InvitationModel) GetForCode(plaintext string) (*Invitation, error) {
+	hash := sha256.Sum256([]byte(plaintext))
+
+	query := `
+    SELECT id, email, invited_by, roles, permissions, created_at, expiry, accepted_at
+    FROM invitations
+    WHERE hash = $1 AND accepted_at IS NULL AND expiry > $2
+  `
+
+	var invitation Invitation
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, hash[:], time.Now()).Scan(
+		&invitation.ID,
+		&invitation.Email,
+		&invitation.InvitedBy,
+		pq.Array(&invitation.Roles),
+		pq.Array(&invitation.Permissions),
+		&invitation.CreatedAt,
+		&invitation.Expiry,
+		&invitation.AcceptedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &invitation, nil
+}
+
+// retrieve the invitations sent by the user, newest first
+func (m InvitationModel) GetAllForInviter(userID int64) ([]*Invitation, error) {
+	query := `
+    SELECT id, email, invited_by, roles, permissions, created_at, expiry, accepted_at
+    FROM invitations
+    WHERE invited_by = $1
+    ORDER BY created_at DESC, id DESC
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	invitations := []*Invitation{}
+
+	for rows.Next() {
+		var invitation Invitation
+
+		err := rows.Scan(
+			&invitation.ID,
+			&invitation.Email,
+			&invitation.InvitedBy,
+			pq.Array(&invitation.Roles),
+			pq.Array(&invitation.Permissions),
+			&invitation.CreatedAt,
+			&invitation.Expiry,
+			&invitation.AcceptedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		invitations = append(invitations, &invitation)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return invitations, nil
+}
+
+// mark the invitation as accepted by the new user, only a pending invitation
+// can be accepted so it returns ErrRecordNotFound when it has been used already
+func (m InvitationModel) Accept(id, userID int64) error {
+	query := `
+    UPDATE invitations
+    SET accepted_at = NOW(), accepted_by = $2
+    WHERE id = $1 AND accepted_at IS NULL AND expiry > NOW()
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// revoke the pending invitation sent by the user
+func (m InvitationModel) Delete(id, userID int64) error {
+	query := `
+    DELETE FROM invitations
+    WHERE id = $1 AND invited_by = $2 AND accepted_at IS NULL
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
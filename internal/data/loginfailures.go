@@ -0,0 +1,129 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// the failed login attempts counted for an email or IP address
+type LoginFailure struct {
+	Key          string     `json:"-"`
+	Failures     int        `json:"failures"`
+	LastFailedAt time.Time  `json:"last_failed_at"`
+	LockedUntil  *time.Time `json:"locked_until"`
+}
+
+// check whether the login is still locked at the given time
+func (f *LoginFailure) IsLocked(now time.Time) bool {
+	return f.LockedUntil != nil && f.LockedUntil.After(now)
+}
+
+// LoginFailureModel type
+type LoginFailureModel struct {
+	DB *sql.DB
+}
+
+// retrieve the failed attempts of the key
+func (m LoginFailureModel) Get(key string) (*LoginFailure, error) {
+	query := `
+    SELECT key, failures, last_failed_at, locked_until
+    FROM login_failures
+    WHERE key = $1
+  `
+
+	var failure LoginFailure
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, key).Scan(&failure.Key, &failure.Failures, &failure.LastFailedAt, &failure.LockedUntil)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &failure, nil
+}
+
+// count another failed attempt and return the number of failures, the count
+// starts over when the previous failure is older than the window
+func (m LoginFailureModel) Record(key string, window time.Duration) (int, error) {
+	query := `
+    INSERT INTO login_failures (key, failures, last_failed_at)
+    VALUES ($1, 1, NOW())
+    ON CONFLICT (key) DO UPDATE
+    SET failures = CASE WHEN login_failures.last_failed_at < $2 THEN 1 ELSE login_failures.failures + 1 END,
+        last_failed_at = NOW()
+    RETURNING failures
+  `
+
+	var failures int
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, key, time.Now().Add(-window)).Scan(&failures)
+
+	return failures, err
+}
+
+// reject the logins of the key until the given time
+func (m LoginFailureModel) Lock(key string, until time.Time) error {
+	query := `
+    UPDATE login_failures
+    SET locked_until = $2
+    WHERE key = $1
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, key, until)
+
+	return err
+}
+
+// forget the failed attempts of the key, either after a successful
+// login or when an administrator unlocks the account
+func (m LoginFailureModel) Delete(key string) (bool, error) {
+	query := `
+    DELETE FROM login_failures
+    WHERE key = $1
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, key)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected > 0, nil
+}
+
+// delete the failed attempts which are neither locked nor recent enough to count
+func (m LoginFailureModel) DeleteExpired(before time.Time) error {
+	query := `
+    DELETE FROM login_failures
+    WHERE last_failed_at < $1 AND (locked_until IS NULL OR locked_until < NOW())
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, before)
+
+	return err
+}
@@ -14,18 +14,38 @@ var (
 
 // create models which wrap MovieModel
 type Models struct {
-	Permissions PermissionModel
-	Movies      MovieModel
-	Users       UserModel
-	Tokens      TokenModel
+	Permissions    PermissionModel
+	Roles          RoleModel
+	Movies         MovieModel
+	Users          UserModel
+	Tokens         TokenModel
+	APIKeys        APIKeyModel
+	Sessions       SessionModel
+	TwoFactor      TwoFactorModel
+	Identities     IdentityModel
+	Invitations    InvitationModel
+	LoginFailures  LoginFailureModel
+	EmailThrottles EmailThrottleModel
+	Tags           TagModel
+	Comments       CommentModel
 }
 
 // return the initialized MovieModel
 func NewModels(db *sql.DB) Models {
 	return Models{
-		Permissions: PermissionModel{DB: db},
-		Movies:      MovieModel{DB: db},
-		Users:       UserModel{DB: db},
-		Tokens:      TokenModel{DB: db},
+		Permissions:    PermissionModel{DB: db},
+		Roles:          RoleModel{DB: db},
+		Movies:         MovieModel{DB: db},
+		Users:          UserModel{DB: db},
+		Tokens:         TokenModel{DB: db},
+		APIKeys:        APIKeyModel{DB: db},
+		Sessions:       SessionModel{DB: db},
+		TwoFactor:      TwoFactorModel{DB: db},
+		Identities:     IdentityModel{DB: db},
+		Invitations:    InvitationModel{DB: db},
+		LoginFailures:  LoginFailureModel{DB: db},
+		EmailThrottles: EmailThrottleModel{DB: db},
+		Tags:           TagModel{DB: db},
+		Comments:       CommentModel{DB: db},
 	}
 }
@@ -236,7 +236,8 @@ func (m MovieModel) Delete(id int64) error {
 }
 
 // fetch all movies
-func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
+// the movie must carry every tag in the tags slice with a positive relevance
+func (m MovieModel) GetAll(title string, genres []string, tags []string, filters Filters) ([]*Movie, Metadata, error) {
 	// use count(*) OVER() to calculate total records according to filter which being applied
 	// query to retrieve all movies
 	query := fmt.Sprintf(`
@@ -244,8 +245,20 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
     FROM movies
 		WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) or $1 = '')
     AND (genres @> $2 OR $2 = '{}')
+    AND (cardinality($3::text[]) = 0 OR id IN (
+      SELECT movies_tags.movie_id
+      FROM movies_tags
+      INNER JOIN tags ON tags.id = movies_tags.tag_id
+      WHERE tags.slug = ANY($3::text[])
+      AND (
+        SELECT COALESCE(SUM(vote), 0) FROM movies_tags_votes
+        WHERE movies_tags_votes.movie_id = movies_tags.movie_id AND movies_tags_votes.tag_id = movies_tags.tag_id
+      ) > 0
+      GROUP BY movies_tags.movie_id
+      HAVING count(*) = cardinality($3::text[])
+    ))
 		ORDER BY %s %s, id ASC
-		LIMIT $3 OFFSET $4
+		LIMIT $4 OFFSET $5
   `, filters.sortColumn(), filters.sortDirection())
 	// context timeout in 3 seconds
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -255,6 +268,7 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 	args := []interface{}{
 		title,
 		pq.Array(genres),
+		pq.Array(tags),
 		filters.limit(),
 		filters.offset(),
 	}
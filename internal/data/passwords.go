@@ -0,0 +1,212 @@
+package data
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"api.cinevie.jpranata.tech/internal/validator"
+)
+
+// the requirements of a new password on top of ValidatePasswordPlaintext
+type PasswordPolicy struct {
+	MinLength  int
+	MinEntropy float64
+	Breached   *BreachedPasswords
+}
+
+// validate a new password of the user, the user's name and email address
+// aren't checked when the user is nil
+func (p PasswordPolicy) Validate(v *validator.Validator, password string, user *User) {
+	ValidatePasswordPlaintext(v, password)
+
+	v.Check(len(password) >= p.MinLength, "password", "must be at least "+strconv.Itoa(p.MinLength)+" characters long.")
+
+	if user != nil {
+		v.Check(!containsPersonalInfo(password, user.Name, user.Email), "password", "must not contain your name or email address.")
+	}
+
+	if p.Breached != nil {
+		v.Check(!p.Breached.Contains(password), "password", "has appeared in a data breach, please choose a different one.")
+	}
+
+	v.Check(PasswordEntropy(password) >= p.MinEntropy, "password", "is too easy to guess, use a longer password or mix in other kinds of characters.")
+}
+
+// estimate the entropy in bits from the size of the character classes being used,
+// the repeated and sequential characters (like "aaa" or "123") barely count
+func PasswordEntropy(password string) float64 {
+	var lower, upper, digit, symbol, other bool
+
+	runes := []rune(password)
+
+	length := 0.0
+
+	for i, r := range runes {
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		case r < unicode.MaxASCII && unicode.IsPrint(r):
+			symbol = true
+		default:
+			other = true
+		}
+
+		if i > 0 {
+			diff := r - runes[i-1]
+			if diff == 0 || diff == 1 || diff == -1 {
+				length += 0.25
+
+				continue
+			}
+		}
+
+		length++
+	}
+
+	pool := 0
+
+	if lower {
+		pool += 26
+	}
+
+	if upper {
+		pool += 26
+	}
+
+	if digit {
+		pool += 10
+	}
+
+	if symbol {
+		pool += 33
+	}
+
+	if other {
+		pool += 100
+	}
+
+	if pool == 0 {
+		return 0
+	}
+
+	return length * math.Log2(float64(pool))
+}
+
+// check whether the password contains a part of the name or email address,
+// the parts shorter than 3 characters are ignored
+func containsPersonalInfo(password, name, email string) bool {
+	password = strings.ToLower(password)
+
+	parts := strings.Fields(strings.ToLower(name))
+
+	if at := strings.LastIndex(email, "@"); at > 0 {
+		parts = append(parts, strings.ToLower(email[:at]))
+	}
+
+	for _, part := range parts {
+		if len(part) >= 3 && strings.Contains(password, part) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// a list of breached passwords indexed by the first 5 hex characters of
+// their SHA-1 hash, the same ranges the k-anonymity APIs respond with
+type BreachedPasswords struct {
+	ranges map[string][]string
+	count  int
+}
+
+// load the list from a file with a password or its SHA-1 hash on every line,
+// the hashes might be followed by ":count" like the downloadable hash lists
+func LoadBreachedPasswords(path string) (*BreachedPasswords, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	breached := &BreachedPasswords{ranges: make(map[string][]string)}
+
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		hash := line
+		if i := strings.IndexByte(hash, ':'); i == 40 {
+			hash = hash[:i]
+		}
+
+		if !isSHA1Hex(hash) {
+			hash = sha1Hex(line)
+		}
+
+		hash = strings.ToUpper(hash)
+
+		breached.ranges[hash[:5]] = append(breached.ranges[hash[:5]], hash[5:])
+		breached.count++
+	}
+
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return breached, nil
+}
+
+// the number of passwords in the list
+func (b *BreachedPasswords) Len() int {
+	return b.count
+}
+
+// the hash suffixes of the breached passwords sharing the hash prefix
+func (b *BreachedPasswords) Range(prefix string) []string {
+	return b.ranges[strings.ToUpper(prefix)]
+}
+
+// check whether the password is in the list, only its hash prefix is used
+// for the lookup so the list could be replaced with a remote range API
+func (b *BreachedPasswords) Contains(password string) bool {
+	hash := sha1Hex(password)
+
+	for _, suffix := range b.Range(hash[:5]) {
+		if suffix == hash[5:] {
+			return true
+		}
+	}
+
+	return false
+}
+
+func sha1Hex(s string) string {
+	sum := sha1.Sum([]byte(s))
+
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+func isSHA1Hex(s string) bool {
+	if len(s) != 40 {
+		return false
+	}
+
+	_, err := hex.DecodeString(s)
+
+	return err == nil
+}
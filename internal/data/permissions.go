@@ -24,20 +24,38 @@ func (p Permissions) Include(code string) bool {
 	return false
 }
 
+// returns the permission codes which are present in both slices
+func (p Permissions) Intersect(other Permissions) Permissions {
+	permissions := Permissions{}
+
+	for i := range p {
+		if other.Include(p[i]) {
+			permissions = append(permissions, p[i])
+		}
+	}
+
+	return permissions
+}
+
 // PermissionModel type
 type PermissionModel struct {
 	DB *sql.DB
 }
 
 // returns the entire permission codes for a specific user in
-// Permissions slice
+// Permissions slice, both the ones granted directly and through roles
 func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
 	query := `
     SELECT permissions.code
     FROM permissions
     INNER JOIN users_permissions on users_permissions.permission_id = permissions.id
-    INNER JOIN users ON users_permissions.user_id = users.id
-    WHERE users.id = $1
+    WHERE users_permissions.user_id = $1
+    UNION
+    SELECT permissions.code
+    FROM permissions
+    INNER JOIN roles_permissions ON roles_permissions.permission_id = permissions.id
+    INNER JOIN users_roles ON users_roles.role_id = roles_permissions.role_id
+    WHERE users_roles.user_id = $1
   `
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -76,6 +94,7 @@ func (m PermissionModel) AddForUser(userID int64, codes ...string) error {
 	query := `
     INSERT INTO users_permissions
     SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
+    ON CONFLICT DO NOTHING
   `
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -85,3 +104,72 @@ func (m PermissionModel) AddForUser(userID int64, codes ...string) error {
 
 	return err
 }
+
+// returns every existing permission code
+func (m PermissionModel) GetAll() (Permissions, error) {
+	query := `
+    SELECT code
+    FROM permissions
+    ORDER BY code ASC
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	permissions := Permissions{}
+
+	for rows.Next() {
+		var permission string
+
+		err := rows.Scan(&permission)
+		if err != nil {
+			return nil, err
+		}
+
+		permissions = append(permissions, permission)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return permissions, nil
+}
+
+// revoke the permission codes granted directly to the user, the ones
+// granted through roles are left untouched
+func (m PermissionModel) RemoveForUser(userID int64, codes ...string) error {
+	query := `
+    DELETE FROM users_permissions
+    USING permissions
+    WHERE users_permissions.permission_id = permissions.id
+    AND users_permissions.user_id = $1
+    AND permissions.code = ANY($2)
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,148 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// named bundle of permission codes like viewer, editor and admin
+type Role struct {
+	ID          int64       `json:"id"`
+	Name        string      `json:"name"`
+	Permissions Permissions `json:"permissions"`
+}
+
+// RoleModel type
+type RoleModel struct {
+	DB *sql.DB
+}
+
+// returns every role along with the permission codes it bundles
+func (m RoleModel) GetAll() ([]*Role, error) {
+	query := `
+    SELECT roles.id, roles.name, COALESCE(array_agg(permissions.code ORDER BY permissions.code) FILTER (WHERE permissions.code IS NOT NULL), '{}')
+    FROM roles
+    LEFT JOIN roles_permissions ON roles_permissions.role_id = roles.id
+    LEFT JOIN permissions ON permissions.id = roles_permissions.permission_id
+    GROUP BY roles.id
+    ORDER BY roles.id ASC
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	roles := []*Role{}
+
+	for rows.Next() {
+		var role Role
+
+		err := rows.Scan(&role.ID, &role.Name, pq.Array(&role.Permissions))
+		if err != nil {
+			return nil, err
+		}
+
+		roles = append(roles, &role)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+// returns the names of the roles assigned to the user
+func (m RoleModel) GetAllForUser(userID int64) ([]string, error) {
+	query := `
+    SELECT roles.name
+    FROM roles
+    INNER JOIN users_roles ON users_roles.role_id = roles.id
+    WHERE users_roles.user_id = $1
+    ORDER BY roles.name ASC
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	roles := []string{}
+
+	for rows.Next() {
+		var role string
+
+		err := rows.Scan(&role)
+		if err != nil {
+			return nil, err
+		}
+
+		roles = append(roles, role)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+// use variadic parameter to assign multiple roles for specific user
+// in single call
+func (m RoleModel) AddForUser(userID int64, names ...string) error {
+	query := `
+    INSERT INTO users_roles
+    SELECT $1, roles.id FROM roles WHERE roles.name = ANY($2)
+    ON CONFLICT DO NOTHING
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(names))
+
+	return err
+}
+
+func (m RoleModel) RemoveForUser(userID int64, names ...string) error {
+	query := `
+    DELETE FROM users_roles
+    USING roles
+    WHERE users_roles.role_id = roles.id
+    AND users_roles.user_id = $1
+    AND roles.name = ANY($2)
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, pq.Array(names))
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
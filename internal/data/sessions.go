@@ -0,0 +1,290 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// a login of the user on a specific device, the authentication
+// tokens belong to a session and are deleted along with it
+type Session struct {
+	ID         int64     `json:"id"`
+	UserID     int64     `json:"-"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	Current    bool      `json:"current"`
+}
+
+// SessionModel type
+type SessionModel struct {
+	DB *sql.DB
+}
+
+func (m SessionModel) Insert(session *Session) error {
+	query := `
+    INSERT INTO sessions (user_id, user_agent, ip)
+    VALUES ($1, $2, $3)
+    RETURNING id, created_at, last_seen_at
+  `
+
+	// the user agent is sent by the client so keep it valid and at a sensible size,
+	// cut on a rune boundary since Postgres rejects invalid UTF-8
+	session.UserAgent = strings.ToValidUTF8(session.UserAgent, "")
+
+	if len(session.UserAgent) > 255 {
+		end := 255
+		for end > 0 && !utf8.RuneStart(session.UserAgent[end]) {
+			end--
+		}
+
+		session.UserAgent = session.UserAgent[:end]
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, session.UserID, session.UserAgent, session.IP).Scan(&session.ID, &session.CreatedAt, &session.LastSeenAt)
+}
+
+// retrieve the session which the unexpired token of the given scope belongs to
+func (m SessionModel) GetForToken(tokenScope, tokenPlaintext string) (*Session, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+    SELECT sessions.id, sessions.user_id, sessions.created_at, sessions.last_seen_at, sessions.user_agent, sessions.ip
+    FROM sessions
+    INNER JOIN tokens ON tokens.session_id = sessions.id
+    WHERE tokens.hash = $1
+    AND tokens.scope = $2
+    AND tokens.expiry > $3
+  `
+
+	var session Session
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, tokenHash[:], tokenScope, time.Now()).Scan(
+		&session.ID,
+		&session.UserID,
+		&session.CreatedAt,
+		&session.LastSeenAt,
+		&session.UserAgent,
+		&session.IP,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	session.Current = true
+
+	return &session, nil
+}
+
+// returns the sessions of the user which still have an unexpired token,
+// the current session is flagged so the client can tell it apart
+func (m SessionModel) GetAllForUser(userID, currentSessionID int64) ([]*Session, error) {
+	query := `
+    SELECT id, user_id, created_at, last_seen_at, user_agent, ip
+    FROM sessions
+    WHERE user_id = $1
+    AND EXISTS (SELECT 1 FROM tokens WHERE tokens.session_id = sessions.id AND tokens.expiry > NOW())
+    ORDER BY last_seen_at DESC, id DESC
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	sessions := []*Session{}
+
+	for rows.Next() {
+		var session Session
+
+		err := rows.Scan(&session.ID, &session.UserID, &session.CreatedAt, &session.LastSeenAt, &session.UserAgent, &session.IP)
+		if err != nil {
+			return nil, err
+		}
+
+		session.Current = session.ID == currentSessionID
+
+		sessions = append(sessions, &session)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// record that the session is still being used and from which IP address
+func (m SessionModel) Touch(id int64, ip string) error {
+	query := `
+    UPDATE sessions
+    SET last_seen_at = NOW(), ip = $2
+    WHERE id = $1
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, id, ip)
+
+	return err
+}
+
+// delete the session of the user along with its tokens
+func (m SessionModel) Delete(id, userID int64) error {
+	query := `
+    DELETE FROM sessions
+    WHERE id = $1 AND user_id = $2
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// delete every session of the user (*to log out everywhere)
+func (m SessionModel) DeleteAllForUser(userID int64) error {
+	query := `
+    DELETE FROM sessions
+    WHERE user_id = $1
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID)
+
+	return err
+}
+
+// delete every session of the user except the current one
+// (*to log out everywhere else)
+func (m SessionModel) DeleteAllForUserExcept(userID, sessionID int64) (int64, error) {
+	query := `
+    DELETE FROM sessions
+    WHERE user_id = $1 AND id <> $2
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, sessionID)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// delete the sessions whose tokens have all expired
+func (m SessionModel) DeleteExpired() (int64, error) {
+	query := `
+    DELETE FROM sessions
+    WHERE NOT EXISTS (SELECT 1 FROM tokens WHERE tokens.session_id = sessions.id AND tokens.expiry > NOW())
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// returns the id of the sessions which have been deleted since the given time,
+// the signed tokens of these sessions must be rejected until they expire
+func (m SessionModel) GetRevokedSince(since time.Time) ([]int64, error) {
+	query := `
+    SELECT session_id
+    FROM revoked_sessions
+    WHERE revoked_at >= $1
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, since)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	ids := []int64{}
+
+	for rows.Next() {
+		var id int64
+
+		err := rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, id)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// forget the revoked sessions whose signed tokens have all expired
+func (m SessionModel) DeleteRevokedBefore(before time.Time) (int64, error) {
+	query := `
+    DELETE FROM revoked_sessions
+    WHERE revoked_at < $1
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,412 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"api.cinevie.jpranata.tech/internal/validator"
+)
+
+var (
+	ErrDuplicateTag = errors.New("duplicate tag")
+)
+
+// user-contributed free-form tag like "time-travel" or "based-on-true-story"
+// the slug is the normalized form which being used for lookup and in URLs
+type Tag struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"-"`
+	Slug      string    `json:"slug"`
+	Name      string    `json:"name"`
+	Movies    int       `json:"movies,omitempty"`
+}
+
+// a tag attached to a specific movie along with the user who added it
+// and the sum of the up and down votes it has received
+type MovieTag struct {
+	Tag
+	AddedBy   *int64 `json:"added_by,omitempty"`
+	Relevance int    `json:"relevance"`
+}
+
+// normalize the tag name into a slug by lowercasing it and collapsing
+// every run of non letter or digit characters into a single hyphen
+func Slugify(name string) string {
+	var b strings.Builder
+
+	hyphen := false
+
+	for _, r := range strings.ToLower(strings.TrimSpace(name)) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+			hyphen = false
+
+			continue
+		}
+
+		if !hyphen && b.Len() > 0 {
+			b.WriteRune('-')
+			hyphen = true
+		}
+	}
+
+	return strings.TrimSuffix(b.String(), "-")
+}
+
+// normalize every value in the slice and drop the empty and duplicate ones
+func SlugifyAll(names []string) []string {
+	slugs := []string{}
+	seen := make(map[string]bool)
+
+	for _, name := range names {
+		if slug := Slugify(name); slug != "" && !seen[slug] {
+			slugs = append(slugs, slug)
+			seen[slug] = true
+		}
+	}
+
+	return slugs
+}
+
+func ValidateTag(v *validator.Validator, tag *Tag) {
+	v.Check(tag.Name != "", "name", "must be provided.")
+	v.Check(len(tag.Name) <= 50, "name", "must not be more than 50 bytes long.")
+	v.Check(tag.Slug != "", "name", "must contain at least one letter or digit.")
+}
+
+func ValidateTagVote(v *validator.Validator, vote int) {
+	v.Check(vote >= -1 && vote <= 1, "vote", "must be -1, 0 or 1.")
+}
+
+// TagModel type
+type TagModel struct {
+	DB *sql.DB
+}
+
+// insert the tag if its slug doesn't exist yet, otherwise fill the struct
+// with the existing record so the first spelling of the name is kept
+func (m TagModel) Upsert(tag *Tag) error {
+	query := `
+    INSERT INTO tags (slug, name)
+    VALUES ($1, $2)
+    ON CONFLICT (slug) DO UPDATE SET slug = EXCLUDED.slug
+    RETURNING id, created_at, name
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, tag.Slug, tag.Name).Scan(&tag.ID, &tag.CreatedAt, &tag.Name)
+}
+
+func (m TagModel) GetBySlug(slug string) (*Tag, error) {
+	query := `
+    SELECT id, created_at, slug, name
+    FROM tags
+    WHERE slug = $1
+  `
+
+	var tag Tag
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, slug).Scan(&tag.ID, &tag.CreatedAt, &tag.Slug, &tag.Name)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &tag, nil
+}
+
+// fetch all tags along with the number of movies they are attached to
+func (m TagModel) GetAll(name string, filters Filters) ([]*Tag, Metadata, error) {
+	query := fmt.Sprintf(`
+    SELECT count(*) OVER(), tags.id, tags.created_at, tags.slug, tags.name, count(movies_tags.movie_id) AS movies
+    FROM tags
+    LEFT JOIN movies_tags ON movies_tags.tag_id = tags.id
+    WHERE (tags.slug LIKE '%%' || $1 || '%%' OR $1 = '')
+    GROUP BY tags.id
+    ORDER BY %s %s, tags.id ASC
+    LIMIT $2 OFFSET $3
+  `, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, Slugify(name), filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	tags := []*Tag{}
+
+	for rows.Next() {
+		var tag Tag
+
+		err := rows.Scan(&totalRecords, &tag.ID, &tag.CreatedAt, &tag.Slug, &tag.Name, &tag.Movies)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		tags = append(tags, &tag)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return tags, metadata, nil
+}
+
+// delete the tag entirely, cascading to every movie it was attached to
+func (m TagModel) Delete(id int64) error {
+	query := `
+    DELETE FROM tags
+    WHERE id = $1
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// returns the tags of a specific movie ordered by their relevance
+func (m TagModel) GetAllForMovie(movieID int64) ([]*MovieTag, error) {
+	query := `
+    SELECT tags.id, tags.created_at, tags.slug, tags.name, movies_tags.user_id, COALESCE(SUM(movies_tags_votes.vote), 0) AS relevance
+    FROM movies_tags
+    INNER JOIN tags ON tags.id = movies_tags.tag_id
+    LEFT JOIN movies_tags_votes ON movies_tags_votes.movie_id = movies_tags.movie_id AND movies_tags_votes.tag_id = movies_tags.tag_id
+    WHERE movies_tags.movie_id = $1
+    GROUP BY tags.id, movies_tags.user_id
+    ORDER BY relevance DESC, tags.slug ASC
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	tags := []*MovieTag{}
+
+	for rows.Next() {
+		var tag MovieTag
+
+		err := rows.Scan(&tag.ID, &tag.CreatedAt, &tag.Slug, &tag.Name, &tag.AddedBy, &tag.Relevance)
+		if err != nil {
+			return nil, err
+		}
+
+		tags = append(tags, &tag)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
+
+// attach a tag to a movie and count it as an up vote from the user who added it,
+// both in a single transaction so the tag never goes without its vote
+func (m TagModel) AddToMovie(movieID, tagID, userID int64) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	query := `
+    INSERT INTO movies_tags (movie_id, tag_id, user_id)
+    VALUES ($1, $2, $3)
+    ON CONFLICT DO NOTHING
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := tx.ExecContext(ctx, query, movieID, tagID, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	// the movie has already been tagged with it
+	if rowsAffected == 0 {
+		return ErrDuplicateTag
+	}
+
+	query = `
+    INSERT INTO movies_tags_votes (movie_id, tag_id, user_id, vote)
+    VALUES ($1, $2, $3, 1)
+    ON CONFLICT (movie_id, tag_id, user_id) DO UPDATE SET vote = EXCLUDED.vote
+  `
+
+	_, err = tx.ExecContext(ctx, query, movieID, tagID, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m TagModel) RemoveFromMovie(movieID, tagID int64) error {
+	query := `
+    DELETE FROM movies_tags
+    WHERE movie_id = $1 AND tag_id = $2
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, movieID, tagID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// record the user's vote (-1 or 1) on the relevance of a movie tag,
+// a vote of 0 withdraws any previous vote
+func (m TagModel) Vote(movieID, tagID, userID int64, vote int) error {
+	query := `
+    INSERT INTO movies_tags_votes (movie_id, tag_id, user_id, vote)
+    SELECT movie_id, tag_id, $3, $4 FROM movies_tags WHERE movie_id = $1 AND tag_id = $2
+    ON CONFLICT (movie_id, tag_id, user_id) DO UPDATE SET vote = EXCLUDED.vote
+  `
+
+	if vote == 0 {
+		query = `
+      DELETE FROM movies_tags_votes
+      WHERE movie_id = $1 AND tag_id = $2 AND user_id = $3
+    `
+	}
+
+	args := []interface{}{movieID, tagID, userID}
+	if vote != 0 {
+		args = append(args, vote)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	// the movie isn't tagged with it, withdrawing a vote which
+	// doesn't exist is not an error
+	if rowsAffected == 0 && vote != 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// the tags a user has added to a movie or voted on
+type TagActivity struct {
+	MovieID int64  `json:"movie_id"`
+	Slug    string `json:"slug"`
+	Name    string `json:"name"`
+	Added   bool   `json:"added"`
+	Vote    int    `json:"vote"`
+}
+
+func (m TagModel) GetAllActivityForUser(userID int64) ([]*TagActivity, error) {
+	query := `
+    SELECT movies_tags.movie_id, tags.slug, tags.name, COALESCE(movies_tags.user_id = $1, false), COALESCE(movies_tags_votes.vote, 0)
+    FROM movies_tags
+    INNER JOIN tags ON tags.id = movies_tags.tag_id
+    LEFT JOIN movies_tags_votes ON movies_tags_votes.movie_id = movies_tags.movie_id
+      AND movies_tags_votes.tag_id = movies_tags.tag_id
+      AND movies_tags_votes.user_id = $1
+    WHERE movies_tags.user_id = $1 OR movies_tags_votes.user_id IS NOT NULL
+    ORDER BY movies_tags.movie_id ASC, tags.slug ASC
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	activities := []*TagActivity{}
+
+	for rows.Next() {
+		var activity TagActivity
+
+		err := rows.Scan(&activity.MovieID, &activity.Slug, &activity.Name, &activity.Added, &activity.Vote)
+		if err != nil {
+			return nil, err
+		}
+
+		activities = append(activities, &activity)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return activities, nil
+}
@@ -0,0 +1,52 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// EmailThrottleModel type
+type EmailThrottleModel struct {
+	DB *sql.DB
+}
+
+// count another email of the key and report whether it's still within the
+// limit of the window, the count starts over once the window is over
+func (m EmailThrottleModel) Allow(key string, limit int, window time.Duration) (bool, error) {
+	query := `
+    INSERT INTO email_throttles (key, window_start, count)
+    VALUES ($1, NOW(), 1)
+    ON CONFLICT (key) DO UPDATE
+    SET count = CASE WHEN email_throttles.window_start < $2 THEN 1 ELSE email_throttles.count + 1 END,
+        window_start = CASE WHEN email_throttles.window_start < $2 THEN NOW() ELSE email_throttles.window_start END
+    RETURNING count
+  `
+
+	var count int
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, key, time.Now().Add(-window)).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+
+	return count <= limit, nil
+}
+
+// delete the throttles whose window is over
+func (m EmailThrottleModel) DeleteExpired(before time.Time) error {
+	query := `
+    DELETE FROM email_throttles
+    WHERE window_start < $1
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, before)
+
+	return err
+}
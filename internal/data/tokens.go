@@ -6,6 +6,7 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"encoding/base32"
+	"errors"
 	"time"
 
 	"api.cinevie.jpranata.tech/internal/validator"
@@ -15,6 +16,15 @@ const (
 	ScopeActivation     = "activation"
 	ScopeAuthentication = "authentication"
 	ScopePasswordReset  = "password-reset"
+	ScopeEmailChange    = "email-change"
+	ScopeRefresh        = "refresh"
+	ScopeMFAPending     = "mfa-pending"
+	ScopeMagicLink      = "magic-link"
+)
+
+var (
+	ErrTokenReused          = errors.New("token reused")
+	ErrTokenRecentlyRotated = errors.New("token recently rotated")
 )
 
 type Token struct {
@@ -23,6 +33,7 @@ type Token struct {
 	UserID    int64     `json:"-"`
 	Expiry    time.Time `json:"expiry"`
 	Scope     string    `json:"-"`
+	SessionID *int64    `json:"-"`
 }
 
 func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error) {
@@ -73,11 +84,24 @@ func (m TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token,
 	return token, err
 }
 
+// create a new authentication or refresh token which belongs to the session
+func (m TokenModel) NewForSession(userID, sessionID int64, ttl time.Duration, scope string) (*Token, error) {
+	token, err := generateToken(userID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	token.SessionID = &sessionID
+
+	err = m.Insert(token)
+	return token, err
+}
+
 // add the data to tokens table
 func (m TokenModel) Insert(token *Token) error {
 	query := `
-    INSERT INTO tokens (hash, user_id, expiry, scope)
-    VALUES ($1, $2, $3, $4)
+    INSERT INTO tokens (hash, user_id, expiry, scope, session_id)
+    VALUES ($1, $2, $3, $4, $5)
   `
 
 	args := []interface{}{
@@ -85,6 +109,7 @@ func (m TokenModel) Insert(token *Token) error {
 		token.UserID,
 		token.Expiry,
 		token.Scope,
+		token.SessionID,
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -110,6 +135,112 @@ func (m TokenModel) DeleteAllForUser(scope string, userID int64) error {
 	return err
 }
 
+// delete all tokens of the session for a specific scope
+// (*the previous access tokens once the refresh token is rotated)
+func (m TokenModel) DeleteAllForSession(scope string, sessionID int64) error {
+	query := `
+    DELETE FROM tokens
+    WHERE scope = $1 and session_id = $2
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, scope, sessionID)
+
+	return err
+}
+
+// mark the unexpired refresh token as used and return it with its user and session,
+// ErrTokenReused is returned along with the token when it had already been used
+// which means it has been stolen and the whole session must be revoked, unless it
+// has been used within the grace period (like by two tabs refreshing at once) when
+// ErrTokenRecentlyRotated is returned along with the token instead, the caller
+// must neither revoke the session nor issue any tokens then
+func (m TokenModel) Rotate(tokenPlaintext string, grace time.Duration) (*Token, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+    UPDATE tokens
+    SET rotated_at = NOW()
+    WHERE hash = $1 AND scope = $2 AND expiry > $3 AND rotated_at IS NULL
+    RETURNING user_id, session_id, expiry
+  `
+
+	token := Token{
+		Hash:  tokenHash[:],
+		Scope: ScopeRefresh,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// the row is locked by the update so two concurrent refreshes can't both succeed
+	err := m.DB.QueryRowContext(ctx, query, token.Hash, token.Scope, time.Now()).Scan(&token.UserID, &token.SessionID, &token.Expiry)
+	if err == nil {
+		return &token, nil
+	}
+
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	// the token is either unknown, expired or has already been used, the grace
+	// period is checked against the database clock which has set rotated_at
+	query = `
+    SELECT user_id, session_id, expiry, rotated_at > NOW() - $3 * INTERVAL '1 millisecond' AND expiry > NOW()
+    FROM tokens
+    WHERE hash = $1 AND scope = $2 AND rotated_at IS NOT NULL
+  `
+
+	var recentlyRotated bool
+
+	err = m.DB.QueryRowContext(ctx, query, token.Hash, token.Scope, grace.Milliseconds()).Scan(&token.UserID, &token.SessionID, &token.Expiry, &recentlyRotated)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	if recentlyRotated {
+		return &token, ErrTokenRecentlyRotated
+	}
+
+	return &token, ErrTokenReused
+}
+
+// delete the unexpired token and return the id of its user, a single statement
+// so two concurrent requests can't both use a single-use token
+func (m TokenModel) Consume(tokenScope, tokenPlaintext string) (int64, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+    DELETE FROM tokens
+    WHERE hash = $1 AND scope = $2 AND expiry > $3
+    RETURNING user_id
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var userID int64
+
+	err := m.DB.QueryRowContext(ctx, query, tokenHash[:], tokenScope, time.Now()).Scan(&userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrRecordNotFound
+		default:
+			return 0, err
+		}
+	}
+
+	return userID, nil
+}
+
 // delete a token for a specific user and scope
 // (*for logout at this time)
 func (m TokenModel) DeleteToken(tokenScope, tokenPlaintext string) error {
@@ -132,3 +263,49 @@ func (m TokenModel) DeleteToken(tokenScope, tokenPlaintext string) error {
 
 	return err
 }
+
+// token details which are safe to be shown to the user,
+// neither the plain text nor the hash is included
+type TokenMetadata struct {
+	Scope  string    `json:"scope"`
+	Expiry time.Time `json:"expiry"`
+}
+
+// returns the metadata of every unexpired token of the user
+func (m TokenModel) GetAllForUser(userID int64) ([]*TokenMetadata, error) {
+	query := `
+    SELECT scope, expiry
+    FROM tokens
+    WHERE user_id = $1 AND expiry > NOW()
+    ORDER BY expiry DESC
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	tokens := []*TokenMetadata{}
+
+	for rows.Next() {
+		var token TokenMetadata
+
+		err := rows.Scan(&token.Scope, &token.Expiry)
+		if err != nil {
+			return nil, err
+		}
+
+		tokens = append(tokens, &token)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
@@ -0,0 +1,270 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"api.cinevie.jpranata.tech/internal/validator"
+)
+
+// the number of one-time recovery codes generated at once
+const RecoveryCodesCount = 10
+
+// the TOTP authenticator of a user, it's only required at login
+// once the enrollment has been confirmed with a valid code
+type TOTP struct {
+	UserID       int64
+	Secret       string
+	CreatedAt    time.Time
+	ConfirmedAt  *time.Time
+	LastUsedStep int64
+}
+
+func (t *TOTP) IsConfirmed() bool {
+	return t.ConfirmedAt != nil
+}
+
+func ValidateTOTPCode(v *validator.Validator, code string) {
+	v.Check(code != "", "code", "must be provided.")
+	v.Check(len(code) == 6, "code", "must be 6 digits long.")
+}
+
+// generate the random recovery codes in the "xxxx-xxxx" format
+func GenerateRecoveryCodes() ([]string, error) {
+	codes := make([]string, RecoveryCodesCount)
+
+	for i := range codes {
+		randomBytes := make([]byte, 5)
+
+		_, err := rand.Read(randomBytes)
+		if err != nil {
+			return nil, err
+		}
+
+		code := strings.ToLower(base32.StdEncoding.EncodeToString(randomBytes))
+		codes[i] = code[:4] + "-" + code[4:]
+	}
+
+	return codes, nil
+}
+
+// the hyphen and the case are ignored so the codes are easy to type
+func hashRecoveryCode(code string) []byte {
+	normalized := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	hash := sha256.Sum256([]byte(normalized))
+
+	return hash[:]
+}
+
+// TwoFactorModel type
+type TwoFactorModel struct {
+	DB *sql.DB
+}
+
+// store a new unconfirmed secret for the user, replacing the previous
+// unconfirmed one, a confirmed secret is never replaced
+func (m TwoFactorModel) SetTOTP(userID int64, secret string) error {
+	query := `
+    INSERT INTO users_totp (user_id, secret)
+    VALUES ($1, $2)
+    ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret, created_at = NOW(), last_used_step = 0
+    WHERE users_totp.confirmed_at IS NULL
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, secret)
+
+	return err
+}
+
+func (m TwoFactorModel) GetTOTP(userID int64) (*TOTP, error) {
+	query := `
+    SELECT user_id, secret, created_at, confirmed_at, last_used_step
+    FROM users_totp
+    WHERE user_id = $1
+  `
+
+	var totp TOTP
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(
+		&totp.UserID,
+		&totp.Secret,
+		&totp.CreatedAt,
+		&totp.ConfirmedAt,
+		&totp.LastUsedStep,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &totp, nil
+}
+
+func (m TwoFactorModel) ConfirmTOTP(userID int64) error {
+	query := `
+    UPDATE users_totp
+    SET confirmed_at = NOW()
+    WHERE user_id = $1 AND confirmed_at IS NULL
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// record the time step of the code which has just been accepted, false is
+// returned when the step (or a later one) has already been used so the
+// same code can't be replayed
+func (m TwoFactorModel) UseTOTPStep(userID, step int64) (bool, error) {
+	query := `
+    UPDATE users_totp
+    SET last_used_step = $2
+    WHERE user_id = $1 AND last_used_step < $2
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected > 0, nil
+}
+
+// remove the authenticator along with the recovery codes
+func (m TwoFactorModel) Delete(userID int64) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM users_recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM users_totp WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// replace every recovery code of the user with the new ones
+func (m TwoFactorModel) ReplaceRecoveryCodes(userID int64, codes []string) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM users_recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	for _, code := range codes {
+		_, err = tx.ExecContext(ctx, `INSERT INTO users_recovery_codes (user_id, hash) VALUES ($1, $2)`, userID, hashRecoveryCode(code))
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// mark the recovery code as used, ErrRecordNotFound is returned when
+// the code doesn't exist or has already been used
+func (m TwoFactorModel) UseRecoveryCode(userID int64, code string) error {
+	query := `
+    UPDATE users_recovery_codes
+    SET used_at = NOW()
+    WHERE user_id = $1 AND hash = $2 AND used_at IS NULL
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, hashRecoveryCode(code))
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// returns the number of recovery codes which haven't been used yet
+func (m TwoFactorModel) CountRecoveryCodes(userID int64) (int, error) {
+	query := `
+    SELECT count(*)
+    FROM users_recovery_codes
+    WHERE user_id = $1 AND used_at IS NULL
+  `
+
+	var count int
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&count)
+
+	return count, err
+}
@@ -5,11 +5,11 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"errors"
+	"fmt"
+	"strings"
 	"time"
 
 	"api.cinevie.jpranata.tech/internal/validator"
-
-	"golang.org/x/crypto/bcrypt"
 )
 
 var (
@@ -29,6 +29,7 @@ type User struct {
 	Email     string    `json:"email"`
 	Password  password  `json:"-"`
 	Activated bool      `json:"activated"`
+	Disabled  bool      `json:"disabled"`
 	Version   int       `json:"-"`
 }
 
@@ -47,10 +48,10 @@ func (u *User) IsAnonymous() bool {
 	return u == AnonymousUser
 }
 
-// calculates the bcrypt hash of plain text password
+// calculates the hash of plain text password with the current algorithm
 // also stores both the hash and the plain text version in the struct
 func (p *password) Set(plaintextPassword string) error {
-	hash, err := bcrypt.GenerateFromPassword([]byte(plaintextPassword), 12)
+	hash, err := hashPassword(plaintextPassword)
 	if err != nil {
 		return err
 	}
@@ -61,22 +62,16 @@ func (p *password) Set(plaintextPassword string) error {
 	return nil
 }
 
-// checks whether the provided plain text password matches the hashed one
+// checks whether the provided plain text password matches the hashed one,
+// the hash is either bcrypt or argon2id
 func (p *password) Matches(plaintextPassword string) (bool, error) {
-	err := bcrypt.CompareHashAndPassword(p.hash, []byte(plaintextPassword))
-
-	// password didn't match
-	if err != nil {
-		switch {
-		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
-			return false, nil
-		default:
-			return false, err
-		}
-	}
+	return comparePassword(p.hash, plaintextPassword)
+}
 
-	// password match
-	return true, nil
+// checks whether the hash has been calculated with an older algorithm or cost
+// and should be calculated again while the plain text password is known
+func (p *password) NeedsRehash() bool {
+	return needsRehash(p.hash)
 }
 
 func ValidateEmail(v *validator.Validator, email string) {
@@ -111,8 +106,8 @@ func ValidateUser(v *validator.Validator, user *User) {
 
 func (m UserModel) Insert(user *User) error {
 	query := `
-    INSERT INTO users (name, email, password_hash, activated)
-    VALUES ($1, $2, $3, $4)
+    INSERT INTO users (name, email, password_hash, activated, disabled)
+    VALUES ($1, $2, $3, $4, $5)
     RETURNING id, created_at, version
   `
 
@@ -121,6 +116,7 @@ func (m UserModel) Insert(user *User) error {
 		user.Email,
 		user.Password.hash,
 		user.Activated,
+		user.Disabled,
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -143,7 +139,7 @@ func (m UserModel) Insert(user *User) error {
 // retrieve the user details based on user's email
 func (m UserModel) GetByEmail(email string) (*User, error) {
 	query := `
-    SELECT id, created_at, name, email, password_hash, activated, version
+    SELECT id, created_at, name, email, password_hash, activated, disabled, version
     FROM users
     WHERE email = $1
   `
@@ -160,6 +156,7 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 		&user.Email,
 		&user.Password.hash,
 		&user.Activated,
+		&user.Disabled,
 		&user.Version,
 	)
 
@@ -180,8 +177,8 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 func (m UserModel) Update(user *User) error {
 	query := `
     UPDATE users
-    SET name = $1, email = $2, password_hash = $3, activated = $4, version = version + 1
-    WHERE id = $5 and version = $6
+    SET name = $1, email = $2, password_hash = $3, activated = $4, disabled = $5, version = version + 1
+    WHERE id = $6 and version = $7
     RETURNING version
   `
 
@@ -190,6 +187,7 @@ func (m UserModel) Update(user *User) error {
 		user.Email,
 		user.Password.hash,
 		user.Activated,
+		user.Disabled,
 		user.ID,
 		user.Version,
 	}
@@ -218,7 +216,7 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
 
 	query := `
-		SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version
+		SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.disabled, users.version
 		FROM users
 		INNER JOIN tokens
 		ON users.id = tokens.user_id
@@ -245,6 +243,191 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 		&user.Email,
 		&user.Password.hash,
 		&user.Activated,
+		&user.Disabled,
+		&user.Version,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
+// store the new email address the user has requested until it's confirmed,
+// any previous pending request of the user is replaced
+func (m UserModel) SetPendingEmail(userID int64, email string) error {
+	query := `
+    INSERT INTO users_email_changes (user_id, email)
+    VALUES ($1, $2)
+    ON CONFLICT (user_id) DO UPDATE SET email = EXCLUDED.email, created_at = NOW()
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, email)
+
+	return err
+}
+
+// retrieve the pending new email address of the user
+func (m UserModel) GetPendingEmail(userID int64) (string, error) {
+	query := `
+    SELECT email
+    FROM users_email_changes
+    WHERE user_id = $1
+  `
+
+	var email string
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&email)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return "", ErrRecordNotFound
+		default:
+			return "", err
+		}
+	}
+
+	return email, nil
+}
+
+func (m UserModel) DeletePendingEmail(userID int64) error {
+	query := `
+    DELETE FROM users_email_changes
+    WHERE user_id = $1
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID)
+
+	return err
+}
+
+// schedule the user to be permanently deleted once the grace period is over
+func (m UserModel) ScheduleDeletion(userID int64) error {
+	query := `
+    UPDATE users
+    SET deletion_requested_at = NOW()
+    WHERE id = $1
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID)
+
+	return err
+}
+
+// cancel the scheduled deletion of the user (*when they log in again
+// during the grace period), returns true if there was one
+func (m UserModel) CancelDeletion(userID int64) (bool, error) {
+	query := `
+    UPDATE users
+    SET deletion_requested_at = NULL
+    WHERE id = $1 AND deletion_requested_at IS NOT NULL
+  `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected > 0, nil
+}
+
+// permanently delete the users who requested their deletion before the
+// provided time, their comments are blanked first since the comments
+// themselves are kept to preserve the threads
+func (m UserModel) DeleteScheduled(before time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	// rollback is a no-op once the transaction has been committed
+	defer tx.Rollback()
+
+	query := `
+    UPDATE comments
+    SET body = '', deleted_at = NOW(), version = version + 1
+    WHERE deleted_at IS NULL AND user_id IN (
+      SELECT id FROM users WHERE deletion_requested_at < $1
+    )
+  `
+
+	_, err = tx.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+
+	query = `
+    DELETE FROM users
+    WHERE deletion_requested_at < $1
+  `
+
+	result, err := tx.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return rowsAffected, tx.Commit()
+}
+
+// retrieve the user details based on user's id
+func (m UserModel) Get(id int64) (*User, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+    SELECT id, created_at, name, email, password_hash, activated, disabled, version
+    FROM users
+    WHERE id = $1
+  `
+
+	var user User
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Disabled,
 		&user.Version,
 	)
 
@@ -259,3 +442,108 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 
 	return &user, nil
 }
+
+// fetch all users whose name or email contains the search term
+func (m UserModel) GetAll(search string, filters Filters) ([]*User, Metadata, error) {
+	query := fmt.Sprintf(`
+    SELECT count(*) OVER(), id, created_at, name, email, password_hash, activated, disabled, version
+    FROM users
+    WHERE (name ILIKE '%%' || $1 || '%%' OR email ILIKE '%%' || $1 || '%%' OR $1 = '')
+    ORDER BY %s %s, id ASC
+    LIMIT $2 OFFSET $3
+  `, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// escape the LIKE wildcards so the search term is matched literally
+	search = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(search)
+
+	rows, err := m.DB.QueryContext(ctx, query, search, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	users := []*User{}
+
+	for rows.Next() {
+		var user User
+
+		err := rows.Scan(
+			&totalRecords,
+			&user.ID,
+			&user.CreatedAt,
+			&user.Name,
+			&user.Email,
+			&user.Password.hash,
+			&user.Activated,
+			&user.Disabled,
+			&user.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		users = append(users, &user)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return users, metadata, nil
+}
+
+// permanently delete the user right away, their comments are blanked first
+func (m UserModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	query := `
+    UPDATE comments
+    SET body = '', deleted_at = NOW(), version = version + 1
+    WHERE user_id = $1 AND deleted_at IS NULL
+  `
+
+	_, err = tx.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	query = `
+    DELETE FROM users
+    WHERE id = $1
+  `
+
+	result, err := tx.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,174 @@
+package jwt
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrExpiredToken = errors.New("expired token")
+)
+
+// the claims carried by the signed authentication tokens, enough for the
+// authenticate middleware to build the user without a database lookup
+type Claims struct {
+	Subject     int64    `json:"sub"`
+	SessionID   int64    `json:"sid"`
+	Activated   bool     `json:"act"`
+	Permissions []string `json:"perms"`
+	IssuedAt    int64    `json:"iat"`
+	ExpiresAt   int64    `json:"exp"`
+}
+
+type header struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ"`
+	KeyID     string `json:"kid"`
+}
+
+// HMAC-SHA256 secret identified by the kid header of the tokens
+type Key struct {
+	ID     string
+	Secret []byte
+}
+
+// the first key signs the new tokens while every key is able to verify them,
+// so a key can be rotated by putting the new one in front and removing the
+// old one once the tokens it signed have expired
+type KeySet struct {
+	keys []Key
+}
+
+// parse the space separated list of "kid:base64-secret" pairs
+func ParseKeySet(s string) (*KeySet, error) {
+	ks := &KeySet{}
+
+	for _, field := range strings.Fields(s) {
+		pair := strings.SplitN(field, ":", 2)
+		if len(pair) != 2 || pair[0] == "" {
+			return nil, fmt.Errorf("jwt: key %q must be in the format kid:secret", field)
+		}
+
+		id := pair[0]
+
+		secret, err := base64.StdEncoding.DecodeString(pair[1])
+		if err != nil {
+			return nil, fmt.Errorf("jwt: key %q: %w", id, err)
+		}
+
+		if len(secret) < 32 {
+			return nil, fmt.Errorf("jwt: key %q must be at least 32 bytes long", id)
+		}
+
+		ks.keys = append(ks.keys, Key{ID: id, Secret: secret})
+	}
+
+	if len(ks.keys) == 0 {
+		return nil, errors.New("jwt: at least one key must be provided")
+	}
+
+	return ks, nil
+}
+
+// returns the HS256 signed token with the claims
+func (ks *KeySet) Sign(claims Claims) (string, error) {
+	key := ks.keys[0]
+
+	headerJSON, err := json.Marshal(header{Algorithm: "HS256", Type: "JWT", KeyID: key.ID})
+	if err != nil {
+		return "", err
+	}
+
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := encode(headerJSON) + "." + encode(claimsJSON)
+
+	return unsigned + "." + encode(sign(key.Secret, unsigned)), nil
+}
+
+// check the signature and the expiry of the token and return its claims
+func (ks *KeySet) Verify(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	var h header
+
+	err := decodeJSON(parts[0], &h)
+	if err != nil || h.Algorithm != "HS256" {
+		return nil, ErrInvalidToken
+	}
+
+	key, found := ks.key(h.KeyID)
+	if !found {
+		return nil, ErrInvalidToken
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if !hmac.Equal(signature, sign(key.Secret, parts[0]+"."+parts[1])) {
+		return nil, ErrInvalidToken
+	}
+
+	var claims Claims
+
+	err = decodeJSON(parts[1], &claims)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return nil, ErrExpiredToken
+	}
+
+	return &claims, nil
+}
+
+// a token looks like a signed one when it has the three dot-separated parts
+func IsToken(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+func (ks *KeySet) key(id string) (Key, bool) {
+	for _, key := range ks.keys {
+		if key.ID == id {
+			return key, true
+		}
+	}
+
+	return Key{}, false
+}
+
+func sign(secret []byte, unsigned string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(unsigned))
+
+	return mac.Sum(nil)
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeJSON(s string, dst interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, dst)
+}
@@ -0,0 +1,6 @@
+DELETE FROM permissions WHERE code = 'tags:moderate';
+
+DROP INDEX IF EXISTS movies_tags_tag_id_idx;
+DROP TABLE IF EXISTS movies_tags_votes;
+DROP TABLE IF EXISTS movies_tags;
+DROP TABLE IF EXISTS tags;
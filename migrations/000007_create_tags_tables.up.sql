@@ -0,0 +1,29 @@
+CREATE TABLE IF NOT EXISTS tags (
+  id bigserial PRIMARY KEY,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  slug text UNIQUE NOT NULL,
+  name text NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS movies_tags (
+  movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+  tag_id bigint NOT NULL REFERENCES tags ON DELETE CASCADE,
+  user_id bigint REFERENCES users ON DELETE SET NULL,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (movie_id, tag_id)
+);
+
+CREATE TABLE IF NOT EXISTS movies_tags_votes (
+  movie_id bigint NOT NULL,
+  tag_id bigint NOT NULL,
+  user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+  vote smallint NOT NULL CHECK (vote IN (-1, 1)),
+  PRIMARY KEY (movie_id, tag_id, user_id),
+  FOREIGN KEY (movie_id, tag_id) REFERENCES movies_tags ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS movies_tags_tag_id_idx ON movies_tags (tag_id);
+
+INSERT INTO permissions (code)
+VALUES
+  ('tags:moderate');
@@ -0,0 +1,6 @@
+DELETE FROM permissions WHERE code = 'comments:moderate';
+
+DROP TABLE IF EXISTS comments_reports;
+DROP INDEX IF EXISTS comments_parent_id_idx;
+DROP INDEX IF EXISTS comments_movie_id_idx;
+DROP TABLE IF EXISTS comments;
@@ -0,0 +1,27 @@
+CREATE TABLE IF NOT EXISTS comments (
+  id bigserial PRIMARY KEY,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+  user_id bigint REFERENCES users ON DELETE SET NULL,
+  parent_id bigint REFERENCES comments ON DELETE CASCADE,
+  body text NOT NULL,
+  deleted_at timestamp(0) with time zone,
+  version integer NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS comments_movie_id_idx ON comments (movie_id, created_at);
+CREATE INDEX IF NOT EXISTS comments_parent_id_idx ON comments (parent_id);
+
+CREATE TABLE IF NOT EXISTS comments_reports (
+  comment_id bigint NOT NULL REFERENCES comments ON DELETE CASCADE,
+  user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  reason text NOT NULL,
+  resolved_at timestamp(0) with time zone,
+  PRIMARY KEY (comment_id, user_id)
+);
+
+INSERT INTO permissions (code)
+VALUES
+  ('comments:moderate');
@@ -0,0 +1,5 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS disabled bool NOT NULL DEFAULT false;
+
+INSERT INTO permissions (code)
+VALUES
+  ('users:admin');
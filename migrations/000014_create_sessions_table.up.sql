@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS sessions (
+  id bigserial PRIMARY KEY,
+  user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  last_seen_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  user_agent text NOT NULL DEFAULT '',
+  ip text NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS sessions_user_id_idx ON sessions (user_id);
+
+-- the existing authentication tokens don't belong to any session
+-- so the users have to log in again
+DELETE FROM tokens WHERE scope = 'authentication';
+
+ALTER TABLE tokens ADD COLUMN session_id bigint REFERENCES sessions ON DELETE CASCADE;
@@ -0,0 +1,2 @@
+-- a refresh token is kept once it has been exchanged so replaying it can be detected
+ALTER TABLE tokens ADD COLUMN rotated_at timestamp(0) with time zone;
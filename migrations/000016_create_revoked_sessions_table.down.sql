@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS sessions_revoke ON sessions;
+DROP FUNCTION IF EXISTS revoke_session();
+DROP TABLE IF EXISTS revoked_sessions;
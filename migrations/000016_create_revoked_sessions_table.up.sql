@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS revoked_sessions (
+  session_id bigint PRIMARY KEY,
+  revoked_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS revoked_sessions_revoked_at_idx ON revoked_sessions (revoked_at);
+
+-- every way a session could be deleted (logout, revocation, password change,
+-- disabled or deleted account) ends up in the revocation list of the signed tokens
+CREATE OR REPLACE FUNCTION revoke_session() RETURNS trigger AS $$
+BEGIN
+  INSERT INTO revoked_sessions (session_id) VALUES (OLD.id) ON CONFLICT DO NOTHING;
+  RETURN OLD;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER sessions_revoke AFTER DELETE ON sessions FOR EACH ROW EXECUTE PROCEDURE revoke_session();
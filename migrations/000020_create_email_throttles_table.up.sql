@@ -0,0 +1,7 @@
+-- how many emails of a kind have been triggered for an address within
+-- the current window, the key is the kind followed by the email address
+CREATE TABLE IF NOT EXISTS email_throttles (
+  key text PRIMARY KEY,
+  window_start timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  count integer NOT NULL DEFAULT 0
+);
@@ -0,0 +1,3 @@
+DELETE FROM permissions WHERE code = 'invites:create';
+
+DROP TABLE IF EXISTS invitations;
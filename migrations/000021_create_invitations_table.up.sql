@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS invitations (
+  id bigserial PRIMARY KEY,
+  hash bytea UNIQUE NOT NULL,
+  email citext NOT NULL,
+  invited_by bigint REFERENCES users ON DELETE SET NULL,
+  roles text[] NOT NULL DEFAULT '{}',
+  permissions text[] NOT NULL DEFAULT '{}',
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  expiry timestamp(0) with time zone NOT NULL,
+  accepted_at timestamp(0) with time zone,
+  accepted_by bigint REFERENCES users ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS invitations_invited_by_idx ON invitations (invited_by);
+
+INSERT INTO permissions (code)
+VALUES
+  ('invites:create');
+
+INSERT INTO roles_permissions
+SELECT roles.id, permissions.id FROM roles, permissions
+WHERE roles.name = 'admin' AND permissions.code = 'invites:create';
@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS comments_reports_unresolved_idx;
+
+-- only the latest report of every user fits in the former primary key
+DELETE FROM comments_reports a USING comments_reports b
+WHERE a.comment_id = b.comment_id AND a.user_id = b.user_id AND a.id < b.id;
+
+ALTER TABLE comments_reports DROP COLUMN IF EXISTS id;
+
+ALTER TABLE comments_reports ADD PRIMARY KEY (comment_id, user_id);
@@ -0,0 +1,6 @@
+ALTER TABLE comments_reports DROP CONSTRAINT IF EXISTS comments_reports_pkey;
+
+ALTER TABLE comments_reports ADD COLUMN IF NOT EXISTS id bigserial PRIMARY KEY;
+
+-- a user can report the comment again once their report has been resolved
+CREATE UNIQUE INDEX IF NOT EXISTS comments_reports_unresolved_idx ON comments_reports (comment_id, user_id) WHERE resolved_at IS NULL;